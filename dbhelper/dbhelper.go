@@ -2,9 +2,12 @@
 package dbhelper
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"log"
 	"net"
+	"strconv"
 	"strings"
 
 	"github.com/jmoiron/sqlx"
@@ -20,6 +23,42 @@ type Processlist struct {
 	Command  string
 	Time     float64
 	State    string
+	Info     sql.NullString
+}
+
+type InnoDBTrx struct {
+	Trx_id              string
+	Trx_state           string
+	Trx_age             int64
+	Trx_mysql_thread_id uint64
+	Trx_query           sql.NullString
+	Trx_operation_state sql.NullString
+	Trx_tables_locked   uint64
+	Trx_rows_locked     uint64
+	Trx_rows_modified   uint64
+	Trx_isolation_level string
+}
+
+type LockWait struct {
+	Waiting_trx_id  string
+	Waiting_thread  uint64
+	Waiting_query   sql.NullString
+	Wait_age        sql.NullInt64
+	Blocking_trx_id string
+	Blocking_thread uint64
+	Blocking_query  sql.NullString
+	Locked_table    sql.NullString
+	Locked_index    sql.NullString
+	Lock_mode       sql.NullString
+}
+
+type MetadataLock struct {
+	Thread_id     uint64
+	Lock_mode     string
+	Lock_duration sql.NullString
+	Lock_type     string
+	Table_schema  sql.NullString
+	Table_name    sql.NullString
 }
 
 type SlaveHosts struct {
@@ -99,6 +138,17 @@ type Privileges struct {
 	Repl_client_priv string
 	Reload_priv      string
 }
+
+/* An account of mysql.user with its authentication and all its global privileges */
+type UserAccount struct {
+	User                  string
+	Host                  string
+	Password              string
+	Authentication_string string
+	Plugin                string
+	Is_role               bool
+	Privileges            map[string]bool
+}
 type SpiderTableNoSync struct {
 	Tbl_src string
 	Tbl_src_link   string
@@ -137,23 +187,58 @@ func GetAddress(host string, port string, socket string) string {
 }
 
 func GetProcesslist(db *sqlx.DB) []Processlist {
-	pl := []Processlist{}
-	err := db.Select(&pl, "SELECT id, user, host, `db` AS `database`, command, time_ms as time, state FROM INFORMATION_SCHEMA.PROCESSLIST")
+	pl, err := GetProcesslistErr(db)
 	if err != nil {
 		log.Fatalln("ERROR: Could not get processlist", err)
 	}
 	return pl
 }
 
+/* Same as GetProcesslist, but returns query errors instead of exiting */
+func GetProcesslistErr(db *sqlx.DB) ([]Processlist, error) {
+	pl := []Processlist{}
+	err := db.Select(&pl, "SELECT id, user, host, `db` AS `database`, command, time_ms as time, state, info FROM INFORMATION_SCHEMA.PROCESSLIST")
+	return pl, err
+}
+
+func GetInnoDBTrx(db *sqlx.DB) ([]InnoDBTrx, error) {
+	trx := []InnoDBTrx{}
+	err := db.Select(&trx, "SELECT trx_id, trx_state, TIMESTAMPDIFF(SECOND, trx_started, NOW()) AS trx_age, trx_mysql_thread_id, trx_query, trx_operation_state, trx_tables_locked, trx_rows_locked, trx_rows_modified, trx_isolation_level FROM information_schema.INNODB_TRX ORDER BY trx_started")
+	return trx, err
+}
+
+/* Returns the InnoDB lock waits with the waiting and the blocking transactions */
+func GetLockWaits(db *sqlx.DB) ([]LockWait, error) {
+	lw := []LockWait{}
+	err := db.Select(&lw, `SELECT r.trx_id AS waiting_trx_id, r.trx_mysql_thread_id AS waiting_thread, r.trx_query AS waiting_query,
+		TIMESTAMPDIFF(SECOND, r.trx_wait_started, NOW()) AS wait_age,
+		b.trx_id AS blocking_trx_id, b.trx_mysql_thread_id AS blocking_thread, b.trx_query AS blocking_query,
+		l.lock_table AS locked_table, l.lock_index AS locked_index, l.lock_mode AS lock_mode
+		FROM information_schema.INNODB_LOCK_WAITS w
+		JOIN information_schema.INNODB_TRX r ON r.trx_id = w.requesting_trx_id
+		JOIN information_schema.INNODB_TRX b ON b.trx_id = w.blocking_trx_id
+		LEFT JOIN information_schema.INNODB_LOCKS l ON l.lock_id = w.requested_lock_id`)
+	return lw, err
+}
+
+/* Requires the metadata_lock_info plugin */
+func GetMetadataLocks(db *sqlx.DB) ([]MetadataLock, error) {
+	ml := []MetadataLock{}
+	err := db.Select(&ml, "SELECT thread_id, lock_mode, lock_duration, lock_type, table_schema, table_name FROM information_schema.METADATA_LOCK_INFO")
+	return ml, err
+}
+
+/* Maps the columns on a copy of the handle, like the SHOW statement helpers */
 func GetPrivileges(db *sqlx.DB, user string, host string) (Privileges, error) {
-	db.MapperFunc(strings.Title)
+	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
 	priv := Privileges{}
 	stmt := "SELECT Select_priv, Process_priv, Super_priv, Repl_slave_priv, Repl_client_priv, Reload_priv FROM mysql.user WHERE user = ? AND host = ?"
-	row := db.QueryRowx(stmt, user, host)
+	row := udb.QueryRowx(stmt, user, host)
 	err := row.StructScan(&priv)
 	if err != nil {
 		if err == sql.ErrNoRows {
-			row := db.QueryRowx(stmt, user, "%")
+			row := udb.QueryRowx(stmt, user, "%")
 			err = row.StructScan(&priv)
 		}
 		return priv, err
@@ -161,25 +246,69 @@ func GetPrivileges(db *sqlx.DB, user string, host string) (Privileges, error) {
 	return priv, err
 }
 
+/* Returns all accounts. Privileges holds every *_priv column of mysql.user, which differ between versions */
+func GetUsers(db *sqlx.DB) ([]UserAccount, error) {
+	rows, err := db.Queryx("SELECT * FROM mysql.user")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var users []UserAccount
+	for rows.Next() {
+		row := make(map[string]interface{})
+		if err = rows.MapScan(row); err != nil {
+			return nil, err
+		}
+		u := UserAccount{Privileges: make(map[string]bool)}
+		for col, v := range row {
+			b, _ := v.([]byte)
+			switch strings.ToLower(col) {
+			case "user":
+				u.User = string(b)
+			case "host":
+				u.Host = string(b)
+			case "password":
+				u.Password = string(b)
+			case "authentication_string":
+				u.Authentication_string = string(b)
+			case "plugin":
+				u.Plugin = string(b)
+			case "is_role":
+				u.Is_role = string(b) == "Y"
+			default:
+				if strings.HasSuffix(col, "_priv") {
+					u.Privileges[col] = string(b) == "Y"
+				}
+			}
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+/* The SHOW statement helpers map the columns on an Unsafe copy of the handle:
+setting the Title mapper on db itself would change the column mapping of every
+later query of the caller, and the SHOW statements return columns SlaveStatus
+does not have, depending on the server version. */
 func GetSlaveStatus(db *sqlx.DB) (SlaveStatus, error) {
-	db.MapperFunc(strings.Title)
 	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
 	ss := SlaveStatus{}
 	err := udb.Get(&ss, "SHOW SLAVE STATUS")
 	return ss, err
 }
 
 func GetMSlaveStatus(db *sqlx.DB, conn string) (SlaveStatus, error) {
-	db.MapperFunc(strings.Title)
 	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
 	ss := SlaveStatus{}
 	err := udb.Get(&ss, "SHOW SLAVE '"+conn+"' STATUS")
 	return ss, err
 }
 
 func GetAllSlavesStatus(db *sqlx.DB) ([]SlaveStatus, error) {
-	db.MapperFunc(strings.Title)
 	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
 	ss := []SlaveStatus{}
 	err := udb.Select(&ss, "SHOW ALL SLAVES STATUS")
 	return ss, err
@@ -204,9 +333,10 @@ func ResetAllSlaves(db *sqlx.DB) error {
 }
 
 func GetMasterStatus(db *sqlx.DB) (MasterStatus, error) {
-	db.MapperFunc(strings.Title)
+	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
 	ms := MasterStatus{}
-	err := db.Get(&ms, "SHOW MASTER STATUS")
+	err := udb.Get(&ms, "SHOW MASTER STATUS")
 	return ms, err
 }
 
@@ -227,14 +357,22 @@ func GetSlaveHosts(db *sqlx.DB) map[string]interface{} {
 }
 
 func GetSlaveHostsArray(db *sqlx.DB) []SlaveHosts {
-	sh := []SlaveHosts{}
-	err := db.Select(&sh, "SHOW SLAVE HOSTS")
+	sh, err := GetSlaveHostsArrayErr(db)
 	if err != nil {
 		log.Fatalln("ERROR: Could not get slave hosts array", err)
 	}
 	return sh
 }
 
+/* Same as GetSlaveHostsArray, but returns query errors instead of exiting */
+func GetSlaveHostsArrayErr(db *sqlx.DB) ([]SlaveHosts, error) {
+	udb := db.Unsafe()
+	udb.MapperFunc(strings.Title)
+	sh := []SlaveHosts{}
+	err := udb.Select(&sh, "SHOW SLAVE HOSTS")
+	return sh, err
+}
+
 func GetSlaveHostsDiscovery(db *sqlx.DB) []string {
 	slaveList := []string{}
 	/* This method does not return the server ports, so we cannot rely on it for the time being. */
@@ -284,6 +422,30 @@ func GetStatusAsInt(db *sqlx.DB) map[string]int64 {
 	return vars
 }
 
+/* Same as GetStatusAsInt, but returns query errors instead of exiting */
+func GetStatusAsIntErr(db *sqlx.DB) (map[string]int64, error) {
+	vars := make(map[string]int64)
+	rows, err := db.Queryx("SELECT Variable_name AS variable_name, Variable_Value AS value FROM information_schema.global_status")
+	if err != nil {
+		return vars, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var value sql.NullString
+		if err = rows.Scan(&name, &value); err != nil {
+			return vars, err
+		}
+		/* Non numeric values such as ON or OFF are left out */
+		f, err := strconv.ParseFloat(value.String, 64)
+		if err != nil {
+			continue
+		}
+		vars[name] = int64(f)
+	}
+	return vars, rows.Err()
+}
+
 func GetVariables(db *sqlx.DB) (map[string]string, error) {
 	type Variable struct {
 		Variable_name string
@@ -305,6 +467,12 @@ func GetVariables(db *sqlx.DB) (map[string]string, error) {
 	return vars, err
 }
 
+func GetEngineInnoDBStatus(db *sqlx.DB) (string, error) {
+	var typ, name, status string
+	err := db.QueryRowx("SHOW ENGINE INNODB STATUS").Scan(&typ, &name, &status)
+	return status, err
+}
+
 func GetVariableByName(db *sqlx.DB, name string) string {
 	var value string
 	err := db.QueryRowx("SELECT Variable_Value AS Value FROM information_schema.global_variables WHERE Variable_Name = ?", name).Scan(&value)
@@ -483,6 +651,69 @@ func CheckLongRunningWrites(db *sqlx.DB, thresh int) int {
 	return count
 }
 
+/* Returns the plan of the statement running in a thread, MariaDB only */
+func ShowExplain(db *sqlx.DB, id uint64) ([]string, [][]string, error) {
+	rows, err := db.Queryx("SHOW EXPLAIN FOR " + strconv.FormatUint(id, 10))
+	if err != nil {
+		return nil, nil, err
+	}
+	return scanStrings(rows)
+}
+
+/* Runs EXPLAIN for a statement, using database as the default schema */
+func Explain(db *sqlx.DB, database string, query string) ([]string, [][]string, error) {
+	ctx := context.Background()
+	conn, err := db.Connx(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer conn.Close()
+	if database != "" {
+		/* The default schema stays set on the connection, discard it instead of
+		returning it to the pool */
+		defer conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		_, err = conn.ExecContext(ctx, "USE `"+strings.Replace(database, "`", "``", -1)+"`")
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	rows, err := conn.QueryxContext(ctx, "EXPLAIN "+query)
+	if err != nil {
+		return nil, nil, err
+	}
+	return scanStrings(rows)
+}
+
+/* Reads a result set of any shape as strings, NULL values are returned as "NULL" */
+func scanStrings(rows *sqlx.Rows) ([]string, [][]string, error) {
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, nil, err
+	}
+	var res [][]string
+	for rows.Next() {
+		raw := make([]sql.RawBytes, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+		if err = rows.Scan(dest...); err != nil {
+			return cols, res, err
+		}
+		row := make([]string, len(cols))
+		for i, b := range raw {
+			if b == nil {
+				row[i] = "NULL"
+			} else {
+				row[i] = string(b)
+			}
+		}
+		res = append(res, row)
+	}
+	return cols, res, rows.Err()
+}
+
 func KillThreads(db *sqlx.DB) {
 	var ids []int
 	db.Select(&ids, "SELECT Id FROM information_schema.PROCESSLIST WHERE Command != 'binlog dump' AND User != 'system user' AND Id != CONNECTION_ID()")
@@ -491,6 +722,52 @@ func KillThreads(db *sqlx.DB) {
 	}
 }
 
+/* Returns true for threads that must never be killed: replication, system and event scheduler threads */
+func IsSystemThread(p Processlist) bool {
+	return p.User == "system user" || p.User == "event_scheduler" || p.Command == "Daemon" || strings.EqualFold(p.Command, "Binlog Dump") || strings.HasPrefix(p.Command, "Slave_")
+}
+
+/* Kills a thread, or only its running statement when query is true */
+func KillThread(db *sqlx.DB, id uint64, query bool) error {
+	stmt := "KILL "
+	if query {
+		stmt += "QUERY "
+	}
+	_, err := db.Exec(stmt + strconv.FormatUint(id, 10))
+	return err
+}
+
+/* Filtered variant of KillThreads: kills the threads of the list, skipping
+system threads, the current connection and the other connections of the caller
+listed in own. Returns the number of killed threads. */
+func KillThreadList(db *sqlx.DB, threads []Processlist, query bool, own ...uint64) (int, error) {
+	var self uint64
+	err := db.Get(&self, "SELECT CONNECTION_ID()")
+	if err != nil {
+		return 0, err
+	}
+	skip := map[uint64]bool{self: true}
+	for _, id := range own {
+		skip[id] = true
+	}
+	killed := 0
+	for _, p := range threads {
+		if skip[p.Id] || IsSystemThread(p) {
+			continue
+		}
+		err = KillThread(db, p.Id, query)
+		if err != nil {
+			// The thread may have ended since the processlist was read
+			if strings.Contains(err.Error(), "Unknown thread id") {
+				continue
+			}
+			return killed, err
+		}
+		killed++
+	}
+	return killed, nil
+}
+
 /* Check if string is an IP address or a hostname, return a IP address */
 func CheckHostAddr(h string) (string, error) {
 	var err error
@@ -0,0 +1,149 @@
+// Package digest normalizes SQL statements into fingerprints, so that
+// statements differing only by their literal values are grouped together.
+package digest
+
+import (
+	"crypto/md5"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var inList = regexp.MustCompile(`\b(in|values) ?\(\?(, ?\?)*\)`)
+var valuesList = regexp.MustCompile(`\b(values) ?\(\?\+\)(, ?\(\?(, ?\?)*\))+`)
+
+// Fingerprint returns the normalized form of a statement: comments removed,
+// literals replaced by ?, IN and VALUES lists collapsed to (?+), whitespace
+// squeezed and everything lowercased.
+func Fingerprint(query string) string {
+	var b strings.Builder
+	q := query
+	space := false
+	for i := 0; i < len(q); i++ {
+		c := q[i]
+		switch {
+		case c == '/' && strings.HasPrefix(q[i:], "/*"):
+			end := strings.Index(q[i+2:], "*/")
+			if end < 0 {
+				i = len(q)
+			} else {
+				i += end + 3
+			}
+			space = true
+			continue
+		case c == '#' || strings.HasPrefix(q[i:], "-- ") || strings.HasPrefix(q[i:], "--\t"):
+			for i < len(q) && q[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			space = true
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		switch {
+		case c == '\'' || c == '"':
+			i = skipQuoted(q, i)
+			b.WriteByte('?')
+		case c == '`':
+			end := strings.IndexByte(q[i+1:], '`')
+			if end < 0 {
+				b.WriteString(q[i:])
+				i = len(q)
+			} else {
+				b.WriteString(q[i : i+end+2])
+				i += end + 1
+			}
+		case isDigit(c) && !isWord(lastByte(b.String())):
+			i = skipNumber(q, i)
+			b.WriteByte('?')
+		case (c == '-' || c == '+') && i+1 < len(q) && isDigit(q[i+1]) && afterOperator(b.String()):
+			i = skipNumber(q, i+1)
+			b.WriteByte('?')
+		default:
+			b.WriteByte(c)
+		}
+	}
+	s := strings.ToLower(b.String())
+	s = inList.ReplaceAllString(s, "$1 (?+)")
+	s = valuesList.ReplaceAllString(s, "$1 (?+)")
+	return s
+}
+
+// Checksum returns a short hexadecimal identifier of a fingerprint.
+func Checksum(fingerprint string) string {
+	sum := md5.Sum([]byte(fingerprint))
+	return fmt.Sprintf("%X", sum[8:])
+}
+
+// Returns the index of the closing quote, handling backslash escapes and
+// doubled quotes.
+func skipQuoted(q string, i int) int {
+	quote := q[i]
+	for j := i + 1; j < len(q); j++ {
+		switch q[j] {
+		case '\\':
+			j++
+		case quote:
+			if j+1 < len(q) && q[j+1] == quote {
+				j++
+				continue
+			}
+			return j
+		}
+	}
+	return len(q) - 1
+}
+
+// Returns the index of the last character of a number, including decimals,
+// exponents and hexadecimal or binary literals.
+func skipNumber(q string, i int) int {
+	if q[i] == '0' && i+1 < len(q) && strings.IndexByte("xXbB", q[i+1]) >= 0 {
+		i += 2
+		for i < len(q) && isHex(q[i]) {
+			i++
+		}
+		return i - 1
+	}
+	for i < len(q) && (isDigit(q[i]) || q[i] == '.') {
+		i++
+	}
+	if i+1 < len(q) && (q[i] == 'e' || q[i] == 'E') && (isDigit(q[i+1]) || q[i+1] == '-' || q[i+1] == '+') {
+		i += 2
+		for i < len(q) && isDigit(q[i]) {
+			i++
+		}
+	}
+	return i - 1
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isHex(c byte) bool {
+	return isDigit(c) || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
+// A digit directly following a word character is part of an identifier.
+func isWord(c byte) bool {
+	return c == '_' || c == '$' || c == '`' || c >= 0x80 || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || isDigit(c)
+}
+
+func lastByte(s string) byte {
+	if s == "" {
+		return 0
+	}
+	return s[len(s)-1]
+}
+
+// A sign belongs to a number when it follows an operator, a comma or an
+// opening parenthesis rather than an operand.
+func afterOperator(s string) bool {
+	s = strings.TrimRight(s, " ")
+	return s == "" || strings.IndexByte("=<>(,+-*/%", lastByte(s)) >= 0
+}
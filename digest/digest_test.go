@@ -0,0 +1,46 @@
+package digest
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  string
+	}{
+		{"literals", "SELECT * FROM t WHERE a = 1 AND b = 'x' AND c = \"y\"", "select * from t where a = ? and b = ? and c = ?"},
+		{"whitespace and case", "  SELECT\n\ta,\r\n  b FROM   T  ", "select a, b from t"},
+		{"escaped quotes", `SELECT 'it\'s', 'a''b' FROM t`, "select ?, ? from t"},
+		{"numbers", "SELECT 1.5, 2e10, -3, 0x1F, 0b101 FROM t", "select ?, ?, ?, ?, ? from t"},
+		{"sign after operand", "SELECT a-1, a - 2 FROM t", "select a-?, a - ? from t"},
+		{"identifiers with digits", "SELECT col1 FROM t2 JOIN `3d` ON t2.id = `3d`.id", "select col1 from t2 join `3d` on t2.id = `3d`.id"},
+		{"in list", "SELECT * FROM t WHERE id IN (1, 2, 3)", "select * from t where id in (?+)"},
+		{"in list without spaces", "SELECT * FROM t WHERE id IN(1,2)", "select * from t where id in (?+)"},
+		{"values rows", "INSERT INTO t VALUES (1, 'a'), (2, 'b'), (3, 'c')", "insert into t values (?+)"},
+		{"single values row", "INSERT INTO t (a, b) VALUES (1, 'a')", "insert into t (a, b) values (?+)"},
+		{"block comment", "SELECT /* hint */ a FROM t", "select a from t"},
+		{"line comments", "SELECT a -- comment\nFROM t # other\nWHERE b = 1", "select a from t where b = ?"},
+		{"unterminated comment", "SELECT a /* never closed", "select a"},
+		{"empty", "", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Fingerprint(tt.query); got != tt.want {
+				t.Errorf("Fingerprint(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	a := Checksum(Fingerprint("SELECT * FROM t WHERE id = 1"))
+	if len(a) != 16 {
+		t.Errorf("Checksum length = %d, want 16", len(a))
+	}
+	if b := Checksum(Fingerprint("select *   from t where id = 42")); a != b {
+		t.Errorf("equivalent statements have different checksums %s and %s", a, b)
+	}
+	if c := Checksum(Fingerprint("SELECT * FROM u WHERE id = 1")); a == c {
+		t.Errorf("different statements share checksum %s", a)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// Severities of the advisor findings, most severe first.
+var severities = []string{"critical", "warning", "notice"}
+
+// rule raises a finding when its condition is true. The message may contain
+// {expression} placeholders, replaced by their value.
+type rule struct {
+	name     string
+	severity string
+	when     expr
+	message  string
+	args     []expr
+}
+
+type finding struct {
+	rule     string
+	severity string
+	message  string
+}
+
+var builtinRules = []struct {
+	name, severity, when, message string
+}{
+	{"Buffer pool vs RAM", "critical",
+		"system.mem_total > 0 and var.innodb_buffer_pool_size > system.mem_total",
+		"InnoDB buffer pool ({bytes(var.innodb_buffer_pool_size)}) is larger than the RAM ({bytes(system.mem_total)}), the server will swap"},
+	{"Buffer pool vs RAM", "warning",
+		"system.mem_total > 0 and var.innodb_buffer_pool_size > system.mem_total * 0.85 and var.innodb_buffer_pool_size <= system.mem_total",
+		"InnoDB buffer pool ({bytes(var.innodb_buffer_pool_size)}) uses {pct(var.innodb_buffer_pool_size, system.mem_total)}% of the RAM, leaving little for connections and the OS"},
+	{"Redo log size", "warning",
+		"status.uptime > 3600 and var.innodb_log_file_size * ifnull(var.innodb_log_files_in_group, 1) * 4 < rate.innodb_os_log_written * 3600",
+		"Redo log ({bytes(var.innodb_log_file_size * ifnull(var.innodb_log_files_in_group, 1))}) holds less than 15 minutes of writes ({bytes(rate.innodb_os_log_written * 3600)} per hour)"},
+	{"Redo log size", "notice",
+		"status.uptime > 3600 and var.innodb_log_file_size * ifnull(var.innodb_log_files_in_group, 1) * 4 >= rate.innodb_os_log_written * 3600 and var.innodb_log_file_size * ifnull(var.innodb_log_files_in_group, 1) < rate.innodb_os_log_written * 3600",
+		"Redo log ({bytes(var.innodb_log_file_size * ifnull(var.innodb_log_files_in_group, 1))}) holds less than one hour of writes ({bytes(rate.innodb_os_log_written * 3600)} per hour)"},
+	{"Temporary tables on disk", "warning",
+		"status.created_tmp_tables > 1000 and status.created_tmp_disk_tables / status.created_tmp_tables > 0.25",
+		"{pct(status.created_tmp_disk_tables, status.created_tmp_tables)}% of temporary tables are created on disk, check tmp_table_size ({bytes(var.tmp_table_size)}), max_heap_table_size ({bytes(var.max_heap_table_size)}) and BLOB/TEXT columns in sorts"},
+	{"Table cache misses", "warning",
+		"status.table_open_cache_hits + status.table_open_cache_misses > 1000 and status.table_open_cache_misses / (status.table_open_cache_hits + status.table_open_cache_misses) > 0.1",
+		"{pct(status.table_open_cache_misses, status.table_open_cache_hits + status.table_open_cache_misses)}% of table opens miss the cache, consider increasing table_open_cache ({var.table_open_cache})"},
+	{"Thread cache hit rate", "warning",
+		"status.connections > 1000 and status.threads_created / status.connections > 0.1",
+		"Thread cache hit rate is {100 - pct(status.threads_created, status.connections)}%, consider increasing thread_cache_size ({var.thread_cache_size})"},
+	{"Connections headroom", "critical",
+		"status.max_used_connections >= var.max_connections",
+		"max_connections ({var.max_connections}) has been reached, clients were likely refused"},
+	{"Connections headroom", "warning",
+		"status.max_used_connections >= var.max_connections * 0.85 and status.max_used_connections < var.max_connections",
+		"Up to {status.max_used_connections} connections were used, {pct(status.max_used_connections, var.max_connections)}% of max_connections ({var.max_connections})"},
+	{"Query cache", "warning",
+		"var.query_cache_type != 'OFF' and var.query_cache_size > 0",
+		"The query cache is enabled ({bytes(var.query_cache_size)}), its global mutex limits concurrency on multi-core servers"},
+	{"Binary log durability", "warning",
+		"var.log_bin == 'ON' and var.sync_binlog == 0",
+		"Binary logging is enabled with sync_binlog = 0, transactions can be lost from the binary log on a crash and replicas diverge"},
+}
+
+var placeholder = regexp.MustCompile(`\{([^{}]+)\}`)
+
+func newRule(name, severity, when, message string) (*rule, error) {
+	found := false
+	for _, s := range severities {
+		found = found || s == severity
+	}
+	if !found {
+		return nil, fmt.Errorf("unknown severity %q, must be one of %s", severity, strings.Join(severities, ", "))
+	}
+	r := &rule{name: name, severity: severity, message: message}
+	var err error
+	if r.when, err = compileExpr(when); err != nil {
+		return nil, fmt.Errorf("condition: %s", err)
+	}
+	for _, m := range placeholder.FindAllStringSubmatch(message, -1) {
+		e, err := compileExpr(m[1])
+		if err != nil {
+			return nil, fmt.Errorf("message {%s}: %s", m[1], err)
+		}
+		r.args = append(r.args, e)
+	}
+	return r, nil
+}
+
+func loadBuiltinRules() []*rule {
+	var rules []*rule
+	for _, b := range builtinRules {
+		r, err := newRule(b.name, b.severity, b.when, b.message)
+		if err != nil {
+			log.Fatalf("ERROR: Builtin rule %s: %s", b.name, err)
+		}
+		rules = append(rules, r)
+	}
+	return rules
+}
+
+// Loads user rules from an ini file, one section per rule:
+//
+//	[Slow queries]
+//	severity = warning
+//	when = status.slow_queries / status.questions > 0.01
+//	message = {pct(status.slow_queries, status.questions)}% of queries are slow
+func loadRules(path string) ([]*rule, error) {
+	cfg, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*rule
+	for _, s := range cfg.Sections() {
+		if s.Name() == ini.DefaultSection {
+			continue
+		}
+		r, err := newRule(s.Name(), s.Key("severity").MustString("warning"), s.Key("when").String(), s.Key("message").MustString(s.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("rule %s: %s", s.Name(), err)
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// Evaluates the rules and returns the findings by decreasing severity. Rules
+// reading values this server does not have are skipped.
+func advise(rules []*rule, env *ruleEnv) []finding {
+	var findings []finding
+	for _, r := range rules {
+		hit, err := evalBool(r.when, env)
+		if err == nil && hit {
+			var msg string
+			msg, err = r.format(env)
+			findings = append(findings, finding{rule: r.name, severity: r.severity, message: msg})
+		}
+		if err != nil && err != errMissing && err != errDivZero {
+			log.Printf("WARN : Rule %s: %s", r.name, err)
+		}
+	}
+	rank := func(s string) int {
+		for i, v := range severities {
+			if v == s {
+				return i
+			}
+		}
+		return len(severities)
+	}
+	sort.SliceStable(findings, func(i, j int) bool {
+		return rank(findings[i].severity) < rank(findings[j].severity)
+	})
+	return findings
+}
+
+func (r *rule) format(env *ruleEnv) (string, error) {
+	var err error
+	n := 0
+	msg := placeholder.ReplaceAllStringFunc(r.message, func(string) string {
+		v, e := r.args[n](env)
+		n++
+		if e != nil {
+			err = e
+			return "?"
+		}
+		if f, ok := v.(float64); ok {
+			if f == math.Trunc(f) {
+				return strconv.FormatFloat(f, 'f', 0, 64)
+			}
+			return strconv.FormatFloat(f, 'f', 1, 64)
+		}
+		return fmt.Sprint(v)
+	})
+	return msg, err
+}
+
+func advisorSection(s *Section, findings []finding) {
+	if len(findings) == 0 {
+		s.add("Findings", "None", unitNone)
+		return
+	}
+	for _, f := range findings {
+		s.addNote(f.rule, f.severity, unitNone, f.message)
+	}
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Variables expected to differ between servers of a same topology, as
+// lowercase patterns.
+var diffIgnored = []string{
+	"server_id", "server_uuid", "hostname", "pid_file", "timestamp", "report_host",
+	"gtid_*_pos", "gtid_executed", "gtid_purged",
+	"log_error", "general_log_file", "slow_query_log_file", "log_bin_basename", "log_bin_index",
+	"relay_log*", "wsrep_node_*", "wsrep_sst_receive_address",
+}
+
+// Subsystems the differences are grouped by, the first matching prefix wins.
+var subsystems = []struct {
+	name     string
+	prefixes []string
+}{
+	{"InnoDB", []string{"innodb_"}},
+	{"Replication", []string{"log_bin", "binlog_", "sync_binlog", "gtid_", "slave_", "replicate_", "relay_", "rpl_", "master_", "read_only", "log_slave_updates", "expire_logs"}},
+	{"Galera", []string{"wsrep_"}},
+	{"Query cache", []string{"query_cache"}},
+	{"MyISAM and Aria", []string{"key_", "myisam_", "aria_", "concurrent_insert", "delay_key_write"}},
+	{"Connections and threads", []string{"max_connect", "max_user_connections", "thread_", "connect_", "wait_timeout", "interactive_timeout", "back_log", "extra_"}},
+	{"Buffers and caches", []string{"table_", "tmp_", "max_heap_table_size", "sort_", "join_", "read_", "max_allowed_packet", "net_"}},
+	{"Logging", []string{"log_", "general_log", "slow_", "long_query_time"}},
+	{"Security", []string{"ssl_", "tls_", "have_ssl", "have_openssl", "require_secure_transport", "local_infile", "secure_", "old_passwords"}},
+	{"Character sets", []string{"character_set", "collation"}},
+	{"Optimizer", []string{"optimizer_", "eq_range", "in_predicate", "join_cache"}},
+	{"Performance schema", []string{"performance_schema"}},
+}
+
+// Status counters compared as per second rates since startup.
+var rateCounters = []string{
+	"COM_", "HANDLER_", "INNODB_ROWS_", "INNODB_DATA_", "INNODB_OS_LOG_", "INNODB_BUFFER_POOL_READ", "INNODB_BUFFER_POOL_WRITE",
+	"BYTES_", "CREATED_", "QUESTIONS", "QUERIES", "SELECT_", "SORT_", "SLOW_QUERIES", "TABLE_LOCKS_", "CONNECTIONS",
+	"ABORTED_", "BINLOG_BYTES", "KEY_READ", "KEY_WRITE", "OPENED_",
+}
+
+// diffSource is one side of a diff: a live server or a saved JSON report.
+type diffSource struct {
+	name      string
+	kernel    string
+	variables map[string]string
+	status    map[string]int64
+}
+
+// Opens a saved report when arg is a file, connects to host[:port] with the
+// credentials of the command line otherwise.
+func loadDiffSource(arg string) (*diffSource, error) {
+	if fi, err := os.Stat(arg); err == nil && !fi.IsDir() {
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return nil, err
+		}
+		var r Report
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.UseNumber()
+		if err = dec.Decode(&r); err != nil {
+			return nil, fmt.Errorf("%s is not a JSON report: %s", arg, err)
+		}
+		if r.Variables == nil {
+			return nil, fmt.Errorf("%s has no variables, it was written by an older version", arg)
+		}
+		return &diffSource{name: fmt.Sprintf("%s (%s)", r.Host, r.Time.Format("2006-01-02 15:04")), kernel: r.Kernel, variables: r.Variables, status: r.Status}, nil
+	}
+	h, p, err := net.SplitHostPort(arg)
+	if err != nil {
+		h, p = arg, *port
+	}
+	db, err := dbhelper.MySQLConnect(*user, *password, dbhelper.GetAddress(h, p, ""))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	src := &diffSource{name: arg, kernel: "n/a"}
+	if src.variables, err = dbhelper.GetVariables(db); err != nil {
+		return nil, err
+	}
+	if src.status, err = dbhelper.GetStatusAsIntErr(db); err != nil {
+		return nil, err
+	}
+	return src, nil
+}
+
+func ignored(name string, extra []string) bool {
+	name = strings.ToLower(name)
+	for _, p := range append(diffIgnored, extra...) {
+		if ok, _ := path.Match(strings.ToLower(strings.TrimSpace(p)), name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func subsystem(name string) string {
+	name = strings.ToLower(name)
+	for _, s := range subsystems {
+		for _, p := range s.prefixes {
+			if strings.HasPrefix(name, p) {
+				return s.name
+			}
+		}
+	}
+	return "Other"
+}
+
+// Builds a report holding the differing values only, each item having the
+// value of the first source and Other the value of the second.
+func diffReport(a, b *diffSource, extraIgnored []string, rates bool, threshold float64) *Report {
+	r := &Report{Host: a.name + " | " + b.name, Kernel: a.kernel + " | " + b.kernel, Time: time.Now()}
+	sections := make(map[string]*Section)
+	section := func(name string) *Section {
+		if s, ok := sections[name]; ok {
+			return s
+		}
+		s := &Section{Name: name}
+		sections[name] = s
+		return s
+	}
+	names := make(map[string]bool)
+	for n := range a.variables {
+		names[n] = true
+	}
+	for n := range b.variables {
+		names[n] = true
+	}
+	for n := range names {
+		va, oka := a.variables[n]
+		vb, okb := b.variables[n]
+		if (oka && okb && va == vb) || ignored(n, extraIgnored) {
+			continue
+		}
+		item := Item{Name: strings.ToLower(n), Value: "(missing)", Other: "(missing)"}
+		if oka {
+			item.Value = va
+		}
+		if okb {
+			item.Other = vb
+		}
+		s := section(subsystem(n))
+		s.Items = append(s.Items, item)
+	}
+	order := []string{}
+	for _, s := range subsystems {
+		order = append(order, s.name)
+	}
+	order = append(order, "Other")
+	for _, name := range order {
+		if s, ok := sections[name]; ok {
+			sort.Slice(s.Items, func(i, j int) bool { return s.Items[i].Name < s.Items[j].Name })
+			r.Sections = append(r.Sections, s)
+		}
+	}
+	if rates {
+		diffRates(r.section("Status rates per second"), a.status, b.status, threshold)
+	}
+	if len(r.Sections) == 0 {
+		r.section("Variables").add("Differences", "None", unitNone)
+	}
+	return r
+}
+
+// Compares the average rates since startup, reporting the counters whose
+// rates differ by more than threshold, relatively to the highest one.
+func diffRates(s *Section, a, b map[string]int64, threshold float64) {
+	if a["UPTIME"] == 0 || b["UPTIME"] == 0 {
+		s.add("Rates", "Unavailable", unitNone)
+		return
+	}
+	var names []string
+	for n := range a {
+		if _, ok := b[n]; !ok {
+			continue
+		}
+		for _, p := range rateCounters {
+			if strings.HasPrefix(n, p) {
+				names = append(names, n)
+				break
+			}
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		ra := float64(a[n]) / float64(a["UPTIME"])
+		rb := float64(b[n]) / float64(b["UPTIME"])
+		max := math.Max(ra, rb)
+		if max < 0.01 || math.Abs(ra-rb)/max <= threshold {
+			continue
+		}
+		s.Items = append(s.Items, Item{Name: strings.ToLower(n), Value: round(ra, 2), Other: round(rb, 2)})
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Expressions used by the advisor rules. They operate on numbers, strings
+// and booleans, and read server data through namespaced names:
+// status.NAME, var.NAME, system.NAME and rate.NAME, case insensitive. A
+// rate is per second, over the -window sampling window or since startup.
+//
+//	status.created_tmp_disk_tables / status.created_tmp_tables > 0.25
+//	var.log_bin == 'ON' and var.sync_binlog == 0
+//
+// Functions: bytes(x) formats a size, pct(a, b) is 100*a/b, ifnull(x, y)
+// returns y when x is not available on this server.
+type expr func(env *ruleEnv) (interface{}, error)
+
+// Evaluation errors meaning the rule does not apply to this server.
+var errMissing = errors.New("value not available")
+var errDivZero = errors.New("division by zero")
+
+// ruleEnv holds the data the expressions are evaluated against.
+type ruleEnv struct {
+	status    map[string]int64
+	variables map[string]string
+	system    map[string]int64
+}
+
+func (e *ruleEnv) lookup(name string) (interface{}, error) {
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return nil, fmt.Errorf("name %s must be prefixed with status., var., system. or rate.", name)
+	}
+	key := strings.ToUpper(name[i+1:])
+	switch strings.ToLower(name[:i]) {
+	case "status":
+		if v, ok := e.status[key]; ok {
+			return float64(v), nil
+		}
+	case "var", "variable":
+		if v, ok := e.variables[key]; ok {
+			return v, nil
+		}
+	case "system":
+		if v, ok := e.system[key]; ok {
+			return float64(v), nil
+		}
+	case "rate":
+		if _, ok := e.status[key]; ok {
+			return rate(key), nil
+		}
+	default:
+		return nil, fmt.Errorf("unknown namespace in %s", name)
+	}
+	return nil, errMissing
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+// Compiles an expression, syntax errors are reported here rather than when
+// the rule is evaluated.
+func compileExpr(s string) (expr, error) {
+	tokens, err := tokenize(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %s", p.tokens[p.pos])
+	}
+	return e, nil
+}
+
+func tokenize(s string) ([]string, error) {
+	var tokens []string
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(s[i+1:], s[i])
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, s[i:i+end+2])
+			i += end + 2
+		case unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' || c == '.':
+			j := i
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, s[i:j])
+			i = j
+		default:
+			op := s[i : i+1]
+			if i+1 < len(s) {
+				switch s[i : i+2] {
+				case "==", "!=", "<=", ">=", "&&", "||", "<>":
+					op = s[i : i+2]
+				}
+			}
+			if !strings.Contains("+-*/%()<>=!,&|", op[:1]) {
+				return nil, fmt.Errorf("unexpected character %q", op)
+			}
+			tokens = append(tokens, op)
+			i += len(op)
+		}
+	}
+	return tokens, nil
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) accept(ops ...string) string {
+	t := p.peek()
+	for _, op := range ops {
+		if strings.EqualFold(t, op) {
+			p.pos++
+			return op
+		}
+	}
+	return ""
+}
+
+func (p *parser) or() (expr, error) {
+	left, err := p.and()
+	for err == nil && p.accept("or", "||") != "" {
+		var right expr
+		if right, err = p.and(); err == nil {
+			left = logical(left, right, true)
+		}
+	}
+	return left, err
+}
+
+func (p *parser) and() (expr, error) {
+	left, err := p.not()
+	for err == nil && p.accept("and", "&&") != "" {
+		var right expr
+		if right, err = p.not(); err == nil {
+			left = logical(left, right, false)
+		}
+	}
+	return left, err
+}
+
+// Both operators short-circuit, so a guard such as status.uptime > 3600
+// keeps the right side from being evaluated too early.
+func logical(left, right expr, or bool) expr {
+	return func(env *ruleEnv) (interface{}, error) {
+		l, err := evalBool(left, env)
+		if err != nil || l == or {
+			return l, err
+		}
+		return evalBool(right, env)
+	}
+}
+
+func evalBool(e expr, env *ruleEnv) (bool, error) {
+	v, err := e(env)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%v is not a condition", v)
+	}
+	return b, nil
+}
+
+func (p *parser) not() (expr, error) {
+	if p.accept("not", "!") != "" {
+		e, err := p.not()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *ruleEnv) (interface{}, error) {
+			b, err := evalBool(e, env)
+			return !b, err
+		}, nil
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (expr, error) {
+	left, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	op := p.accept("==", "=", "!=", "<>", "<=", ">=", "<", ">")
+	if op == "" {
+		return left, nil
+	}
+	right, err := p.sum()
+	if err != nil {
+		return nil, err
+	}
+	return func(env *ruleEnv) (interface{}, error) {
+		l, err := left(env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := right(env)
+		if err != nil {
+			return nil, err
+		}
+		return compare(l, r, op)
+	}, nil
+}
+
+// Values are compared as numbers when both sides convert to a number, as
+// case insensitive strings otherwise.
+func compare(l, r interface{}, op string) (interface{}, error) {
+	lf, lok := toFloat(l)
+	rf, rok := toFloat(r)
+	if lok && rok {
+		switch op {
+		case "==", "=":
+			return lf == rf, nil
+		case "!=", "<>":
+			return lf != rf, nil
+		case "<":
+			return lf < rf, nil
+		case "<=":
+			return lf <= rf, nil
+		case ">":
+			return lf > rf, nil
+		case ">=":
+			return lf >= rf, nil
+		}
+	}
+	ls, rs := fmt.Sprint(l), fmt.Sprint(r)
+	switch op {
+	case "==", "=":
+		return strings.EqualFold(ls, rs), nil
+	case "!=", "<>":
+		return !strings.EqualFold(ls, rs), nil
+	}
+	return nil, fmt.Errorf("cannot compare %q and %q with %s", ls, rs, op)
+}
+
+func (p *parser) sum() (expr, error) {
+	left, err := p.product()
+	for err == nil {
+		op := p.accept("+", "-")
+		if op == "" {
+			break
+		}
+		var right expr
+		if right, err = p.product(); err == nil {
+			left = arithmetic(left, right, op)
+		}
+	}
+	return left, err
+}
+
+func (p *parser) product() (expr, error) {
+	left, err := p.unary()
+	for err == nil {
+		op := p.accept("*", "/", "%")
+		if op == "" {
+			break
+		}
+		var right expr
+		if right, err = p.unary(); err == nil {
+			left = arithmetic(left, right, op)
+		}
+	}
+	return left, err
+}
+
+func arithmetic(left, right expr, op string) expr {
+	return func(env *ruleEnv) (interface{}, error) {
+		l, err := evalNumber(left, env)
+		if err != nil {
+			return nil, err
+		}
+		r, err := evalNumber(right, env)
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case "+":
+			return l + r, nil
+		case "-":
+			return l - r, nil
+		case "*":
+			return l * r, nil
+		}
+		if r == 0 {
+			return nil, errDivZero
+		}
+		if op == "%" {
+			return math.Mod(l, r), nil
+		}
+		return l / r, nil
+	}
+}
+
+func evalNumber(e expr, env *ruleEnv) (float64, error) {
+	v, err := e(env)
+	if err != nil {
+		return 0, err
+	}
+	f, ok := toFloat(v)
+	if !ok {
+		return 0, fmt.Errorf("%v is not a number", v)
+	}
+	return f, nil
+}
+
+func (p *parser) unary() (expr, error) {
+	if p.accept("-") != "" {
+		e, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return func(env *ruleEnv) (interface{}, error) {
+			f, err := evalNumber(e, env)
+			return -f, err
+		}, nil
+	}
+	return p.primary()
+}
+
+func (p *parser) primary() (expr, error) {
+	t := p.peek()
+	switch {
+	case t == "":
+		return nil, fmt.Errorf("unexpected end of expression")
+	case t == "(":
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.accept(")") == "" {
+			return nil, fmt.Errorf("missing )")
+		}
+		return e, nil
+	case t[0] == '\'' || t[0] == '"':
+		p.pos++
+		s := t[1 : len(t)-1]
+		return func(*ruleEnv) (interface{}, error) { return s, nil }, nil
+	case t[0] >= '0' && t[0] <= '9':
+		p.pos++
+		f, err := strconv.ParseFloat(t, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %s", t)
+		}
+		return func(*ruleEnv) (interface{}, error) { return f, nil }, nil
+	case unicode.IsLetter(rune(t[0])) || t[0] == '_':
+		p.pos++
+		if p.accept("(") != "" {
+			return p.call(strings.ToLower(t))
+		}
+		switch strings.ToLower(t) {
+		case "true":
+			return func(*ruleEnv) (interface{}, error) { return true, nil }, nil
+		case "false":
+			return func(*ruleEnv) (interface{}, error) { return false, nil }, nil
+		}
+		return func(env *ruleEnv) (interface{}, error) { return env.lookup(t) }, nil
+	}
+	return nil, fmt.Errorf("unexpected %s", t)
+}
+
+func (p *parser) call(name string) (expr, error) {
+	var args []expr
+	for p.accept(")") == "" {
+		if len(args) > 0 && p.accept(",") == "" {
+			return nil, fmt.Errorf("missing , or ) in %s()", name)
+		}
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, e)
+	}
+	arity := map[string]int{"bytes": 1, "pct": 2, "ifnull": 2}
+	n, ok := arity[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != n {
+		return nil, fmt.Errorf("%s() takes %d arguments", name, n)
+	}
+	switch name {
+	case "bytes":
+		return func(env *ruleEnv) (interface{}, error) {
+			f, err := evalNumber(args[0], env)
+			return humanize.IBytes(uint64(f)), err
+		}, nil
+	case "pct":
+		return arithmetic(arithmetic(args[0], args[1], "/"), func(*ruleEnv) (interface{}, error) { return 100.0, nil }, "*"), nil
+	}
+	return func(env *ruleEnv) (interface{}, error) {
+		v, err := args[0](env)
+		if err == errMissing {
+			return args[1](env)
+		}
+		return v, err
+	}, nil
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"testing"
+)
+
+func testEnv() *ruleEnv {
+	status = map[string]int64{"UPTIME": 10, "QUESTIONS": 100, "CREATED_TMP_TABLES": 0}
+	windowElapsed = 0
+	return &ruleEnv{
+		status:    status,
+		variables: map[string]string{"LOG_BIN": "ON", "SYNC_BINLOG": "0", "MAX_CONNECTIONS": "151"},
+		system:    map[string]int64{"MEM_TOTAL": 1 << 30},
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	tests := []struct {
+		expr string
+		want interface{}
+	}{
+		// Precedence and associativity
+		{"1 + 2 * 3", 7.0},
+		{"(1 + 2) * 3", 9.0},
+		{"10 - 4 - 3", 3.0},
+		{"12 / 3 / 2", 2.0},
+		{"-2 * -3", 6.0},
+		{"7 % 4", 3.0},
+		{"2 * 3 > 5 and 1 < 2", true},
+		{"true or false and false", true},
+		{"(true or false) and false", false},
+		{"not 1 > 2", true},
+		{"! true || true", true},
+		// Namespaces, case insensitive
+		{"status.uptime", 10.0},
+		{"STATUS.Uptime >= 10", true},
+		{"var.max_connections * 2", 302.0},
+		{"system.mem_total / 1024", 1048576.0},
+		{"rate.questions", 10.0},
+		// Strings compare case insensitively, numbers as numbers
+		{"var.log_bin == 'on'", true},
+		{"var.log_bin != \"OFF\"", true},
+		{"var.sync_binlog == 0", true},
+		{"var.max_connections <> 151.0", false},
+		// Functions
+		{"pct(1, 4)", 25.0},
+		{"bytes(1024)", "1.0 KiB"},
+		{"ifnull(var.missing, 5)", 5.0},
+		{"ifnull(status.uptime, 5)", 10.0},
+		// Short-circuits skip the missing and the invalid right side
+		{"false and status.missing > 0", false},
+		{"true or 1 / 0 > 1", true},
+		{"status.created_tmp_tables > 0 and status.uptime / status.created_tmp_tables > 1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := compileExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("compile error: %s", err)
+			}
+			got, err := e(testEnv())
+			if err != nil {
+				t.Fatalf("eval error: %s", err)
+			}
+			if got != tt.want {
+				t.Errorf("got %v (%T), want %v (%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprEvalErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want error
+	}{
+		{"1 / 0", errDivZero},
+		{"5 % 0", errDivZero},
+		{"status.uptime / status.created_tmp_tables", errDivZero},
+		{"pct(1, 0)", errDivZero},
+		{"ifnull(1 / 0, 5)", errDivZero},
+		{"status.missing > 0", errMissing},
+		{"var.missing == 'ON'", errMissing},
+		{"true and system.missing > 0", errMissing},
+		{"rate.missing", errMissing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			e, err := compileExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("compile error: %s", err)
+			}
+			if _, err = e(testEnv()); err != tt.want {
+				t.Errorf("got error %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestExprCompileErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"'unterminated",
+		"1 @ 2",
+		"unknown(1)",
+		"pct(1)",
+		"ifnull(1, 2, 3)",
+		"bytes(1 2)",
+	} {
+		if _, err := compileExpr(expr); err == nil {
+			t.Errorf("compileExpr(%q) did not fail", expr)
+		}
+	}
+}
+
+func TestExprRuntimeErrors(t *testing.T) {
+	for _, expr := range []string{
+		"uptime > 1",
+		"other.uptime > 1",
+		"'abc' + 1",
+		"'abc' < 'abd'",
+	} {
+		e, err := compileExpr(expr)
+		if err != nil {
+			t.Fatalf("compileExpr(%q): %s", expr, err)
+		}
+		if _, err = e(testEnv()); err == nil || err == errMissing || err == errDivZero {
+			t.Errorf("%q: got error %v, want an evaluation error", expr, err)
+		}
+	}
+	// A condition must evaluate to a boolean
+	e, _ := compileExpr("1 + 1")
+	if _, err := evalBool(e, testEnv()); err == nil {
+		t.Error("evalBool of a number did not fail")
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	rules := loadBuiltinRules()
+	env := testEnv()
+	env.status["MAX_USED_CONNECTIONS"] = 151
+	findings := advise(rules, env)
+	got := make(map[string]string)
+	for _, f := range findings {
+		got[f.rule] = f.severity
+	}
+	if got["Connections headroom"] != "critical" {
+		t.Errorf("Connections headroom = %q, want critical", got["Connections headroom"])
+	}
+	if got["Binary log durability"] != "warning" {
+		t.Errorf("Binary log durability = %q, want warning", got["Binary log durability"])
+	}
+	if findings[0].severity != "critical" {
+		t.Errorf("first finding is %s, want the critical ones first", findings[0].severity)
+	}
+}
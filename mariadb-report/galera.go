@@ -0,0 +1,137 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/common"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Adds the Galera section when the server runs with wsrep enabled.
+func galeraSection(db *sqlx.DB, r *Report) {
+	if variable["WSREP_ON"] != "ON" {
+		return
+	}
+	s := r.section("Galera")
+	wsrep := dbhelper.GetStatus(db)
+	options := providerOptions(variable["WSREP_PROVIDER_OPTIONS"])
+	s.add("Cluster name", variable["WSREP_CLUSTER_NAME"], unitNone)
+	s.add("Cluster size", status["WSREP_CLUSTER_SIZE"], unitNone)
+	s.add("Cluster status", wsrep["WSREP_CLUSTER_STATUS"], unitNone)
+	s.add("State UUID", wsrep["WSREP_CLUSTER_STATE_UUID"], unitNone)
+	s.add("Node name", variable["WSREP_NODE_NAME"], unitNone)
+	s.add("Local state", wsrep["WSREP_LOCAL_STATE_COMMENT"], unitNone)
+	s.add("Provider version", wsrep["WSREP_PROVIDER_VERSION"], unitNone)
+	gcache := parseSize(options["gcache.size"])
+	s.add("Gcache size", gcache, unitBytes)
+	threads, ok := variable["WSREP_SLAVE_THREADS"]
+	if !ok {
+		threads = variable["WSREP_APPLIER_THREADS"]
+	}
+	s.add("Applier threads", common.StrtoInt(threads), unitNone)
+	s.add("Flow control limit", common.StrtoInt(options["gcs.fc_limit"]), unitNone)
+	paused := common.StrtoFloat(wsrep["WSREP_FLOW_CONTROL_PAUSED"])
+	s.add("Flow control paused", round(paused*100, 2), unitPercent)
+	s.add("Flow control sent", status["WSREP_FLOW_CONTROL_SENT"], unitNone)
+	s.add("Flow control received", status["WSREP_FLOW_CONTROL_RECV"], unitNone)
+	s.add("Recv queue avg", common.StrtoFloat(wsrep["WSREP_LOCAL_RECV_QUEUE_AVG"]), unitNone)
+	s.add("Send queue avg", common.StrtoFloat(wsrep["WSREP_LOCAL_SEND_QUEUE_AVG"]), unitNone)
+	commits := status["WSREP_LOCAL_COMMITS"]
+	s.addNote("Cert failures", status["WSREP_LOCAL_CERT_FAILURES"], unitNone, ratio(status["WSREP_LOCAL_CERT_FAILURES"], commits)+" of local commits")
+	s.addNote("Brute force aborts", status["WSREP_LOCAL_BF_ABORTS"], unitNone, ratio(status["WSREP_LOCAL_BF_ABORTS"], commits)+" of local commits")
+	s.add("SST method", variable["WSREP_SST_METHOD"], unitNone)
+	s.add("SST donor", variable["WSREP_SST_DONOR"], unitNone)
+	for _, c := range galeraChecks(gcache) {
+		s.addNote(c.name, c.result, unitNone, c.detail)
+	}
+}
+
+type galeraCheck struct {
+	name, result, detail string
+}
+
+// Sanity checks of the SST, donor and cluster configuration.
+func galeraChecks(gcache uint64) []galeraCheck {
+	var checks []galeraCheck
+	method := variable["WSREP_SST_METHOD"]
+	switch {
+	case method == "mysqldump" || method == "rsync":
+		checks = append(checks, galeraCheck{"SST check", "warning", method + " blocks the donor for the whole transfer, mariabackup does not"})
+	case strings.HasPrefix(method, "mariabackup") || strings.HasPrefix(method, "xtrabackup"):
+		if variable["WSREP_SST_AUTH"] == "" {
+			checks = append(checks, galeraCheck{"SST check", "warning", method + " needs credentials in wsrep_sst_auth unless the socket authentication is used"})
+		}
+	}
+	donors := strings.Split(variable["WSREP_SST_DONOR"], ",")
+	for _, d := range donors {
+		if d != "" && d == variable["WSREP_NODE_NAME"] {
+			checks = append(checks, galeraCheck{"Donor check", "warning", "wsrep_sst_donor lists this node (" + d + ")"})
+		}
+	}
+	if variable["WSREP_SST_DONOR"] != "" && !strings.HasSuffix(variable["WSREP_SST_DONOR"], ",") {
+		checks = append(checks, galeraCheck{"Donor check", "notice", "wsrep_sst_donor has no trailing comma, the SST fails if none of the listed donors is available"})
+	}
+	if size := status["WSREP_CLUSTER_SIZE"]; size > 0 && size%2 == 0 {
+		checks = append(checks, galeraCheck{"Quorum check", "warning", "an even number of nodes cannot keep a quorum when split in halves, consider garbd"})
+	}
+	written := status["WSREP_REPLICATED_BYTES"] + status["WSREP_RECEIVED_BYTES"]
+	if uptime := status["UPTIME"]; gcache > 0 && uptime > 3600 && written > 0 {
+		perHour := uint64(written / uptime * 3600)
+		if gcache < perHour {
+			checks = append(checks, galeraCheck{"Gcache check", "notice", "gcache holds less than one hour of writesets, nodes down longer need a full SST instead of IST"})
+		}
+	}
+	if len(checks) == 0 {
+		checks = append(checks, galeraCheck{"Configuration checks", "OK", ""})
+	}
+	return checks
+}
+
+// Parses wsrep_provider_options, "key = value; key = value".
+func providerOptions(s string) map[string]string {
+	options := make(map[string]string)
+	for _, o := range strings.Split(s, ";") {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) == 2 {
+			options[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return options
+}
+
+// Parses a Galera size such as 128M, suffixes are powers of 1024.
+func parseSize(s string) uint64 {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0
+	}
+	var mult uint64 = 1
+	switch s[len(s)-1] {
+	case 'K', 'k':
+		mult = 1 << 10
+	case 'M', 'm':
+		mult = 1 << 20
+	case 'G', 'g':
+		mult = 1 << 30
+	case 'T', 't':
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	return common.StrtoUint(s) * mult
+}
+
+func ratio(n int64, total int64) string {
+	if total == 0 {
+		return "0%"
+	}
+	return strconv.FormatFloat(round(100*float64(n)/float64(total), 2), 'f', -1, 64) + "%"
+}
+
+func round(f float64, decimals int) float64 {
+	p, _ := strconv.ParseFloat(strconv.FormatFloat(f, 'f', decimals, 64), 64)
+	return p
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"time"
+)
+
+// Units of the report values, they decide how values are rendered as text.
+const (
+	unitNone    = ""
+	unitBytes   = "bytes"
+	unitPercent = "%"
+	unitTime    = "time"
+	unitSeconds = "s"
+	// Rates
+	unitPerSecond    = "/s"
+	unitBytesPerHour = "bytes/h"
+)
+
+// Report is the structured form of a server report. The JSON rendering is
+// meant to be archived and read back, so field names must remain stable.
+type Report struct {
+	Host     string     `json:"host"`
+	Kernel   string     `json:"kernel"`
+	Time     time.Time  `json:"time"`
+	Sections []*Section `json:"sections"`
+	// Raw server values, kept in JSON reports so they can be compared later
+	Variables map[string]string `json:"variables,omitempty"`
+	Status    map[string]int64  `json:"status,omitempty"`
+}
+
+// Section groups the items of one subsystem.
+type Section struct {
+	Name  string `json:"name"`
+	Items []Item `json:"items"`
+}
+
+// Item is one reported value. Value holds the raw machine value (a number,
+// a string or a boolean) and Note an optional human description of it. Other
+// is only set in diffs, with the value of the second server.
+type Item struct {
+	Name  string      `json:"name"`
+	Value interface{} `json:"value"`
+	Other interface{} `json:"other,omitempty"`
+	Unit  string      `json:"unit,omitempty"`
+	Note  string      `json:"note,omitempty"`
+}
+
+func (r *Report) section(name string) *Section {
+	s := &Section{Name: name}
+	r.Sections = append(r.Sections, s)
+	return s
+}
+
+func (s *Section) add(name string, value interface{}, unit string) {
+	s.Items = append(s.Items, Item{Name: name, Value: value, Unit: unit})
+}
+
+func (s *Section) addNote(name string, value interface{}, unit string, note string) {
+	s.Items = append(s.Items, Item{Name: name, Value: value, Unit: unit, Note: note})
+}
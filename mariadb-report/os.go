@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// The /proc and /sys files describe the machine the report runs on, they are
+// only read when the server is on the same machine.
+func isLocal() bool {
+	switch *host {
+	case "", "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+// Returns the content of a small file without the trailing newline, or an
+// empty string when it cannot be read.
+func readFile(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// Returns the kernel as uname -srm does.
+func kernelVersion() string {
+	if !isLocal() {
+		return "n/a (remote server)"
+	}
+	machine := runtime.GOARCH
+	switch machine {
+	case "amd64":
+		machine = "x86_64"
+	case "arm64":
+		machine = "aarch64"
+	case "386":
+		machine = "i686"
+	}
+	return readFile("/proc/sys/kernel/ostype") + " " + readFile("/proc/sys/kernel/osrelease") + " " + machine
+}
+
+// Reads the /proc/meminfo values in bytes.
+func meminfo() map[string]int64 {
+	mem := make(map[string]int64)
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return mem
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		if kb, err := strconv.ParseInt(fields[1], 10, 64); err == nil {
+			mem[strings.TrimSuffix(fields[0], ":")] = kb * 1024
+		}
+	}
+	return mem
+}
+
+// Returns the system values available to the advisor rules, in bytes.
+func systemValues() map[string]int64 {
+	sys := make(map[string]int64)
+	if !isLocal() {
+		return sys
+	}
+	names := map[string]string{"MemTotal": "MEM_TOTAL", "MemAvailable": "MEM_AVAILABLE", "SwapTotal": "SWAP_TOTAL", "SwapFree": "SWAP_FREE"}
+	for k, v := range meminfo() {
+		if name, ok := names[k]; ok {
+			sys[name] = v
+		}
+	}
+	return sys
+}
+
+// Adds the operating system section, flagging the settings known to hurt
+// database servers.
+func osSection(s *Section) {
+	if !isLocal() {
+		s.addNote("Available", "no", unitNone, "the server is remote, run the report on the database host")
+		return
+	}
+	mem := meminfo()
+	if total, ok := mem["MemTotal"]; ok {
+		s.add("RAM", total, unitBytes)
+		s.add("RAM available", mem["MemAvailable"], unitBytes)
+		s.add("Swap", mem["SwapTotal"], unitBytes)
+		s.add("Swap used", mem["SwapTotal"]-mem["SwapFree"], unitBytes)
+	}
+	if v := readFile("/proc/sys/vm/swappiness"); v != "" {
+		if n, _ := strconv.Atoi(v); n > 10 {
+			s.addNote("Swappiness", n, unitNone, "the kernel may swap out the buffer pool, 1 is recommended")
+		} else {
+			s.add("Swappiness", n, unitNone)
+		}
+	}
+	cpus, model := cpuinfo()
+	s.add("CPUs", cpus, unitNone)
+	if model != "" {
+		s.add("CPU model", model, unitNone)
+	}
+	if thp := selected(readFile("/sys/kernel/mm/transparent_hugepage/enabled")); thp == "always" {
+		s.addNote("Transparent huge pages", thp, unitNone, "causes memory bloat and latency stalls, set it to madvise or never")
+	} else if thp != "" {
+		s.add("Transparent huge pages", thp, unitNone)
+	}
+	datadirMount(s, variable["DATADIR"])
+	processLimits(s)
+	numa(s)
+}
+
+// Returns the bracketed choice of a /sys setting such as "always [madvise] never".
+func selected(s string) string {
+	i := strings.IndexByte(s, '[')
+	j := strings.IndexByte(s, ']')
+	if i < 0 || j < i {
+		return s
+	}
+	return s[i+1 : j]
+}
+
+func cpuinfo() (int, string) {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return runtime.NumCPU(), ""
+	}
+	defer f.Close()
+	var count int
+	var model string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "processor":
+			count++
+		case "model name":
+			model = strings.TrimSpace(kv[1])
+		}
+	}
+	if count == 0 {
+		count = runtime.NumCPU()
+	}
+	return count, model
+}
+
+// Finds the mount holding the datadir in mountinfo, then the I/O scheduler
+// of its block device.
+func datadirMount(s *Section, datadir string) {
+	if datadir == "" {
+		return
+	}
+	if p, err := filepath.EvalSymlinks(datadir); err == nil {
+		datadir = p
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	var device, mountPoint, options, fstype string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		sep := -1
+		for i, f := range fields {
+			if f == "-" {
+				sep = i
+				break
+			}
+		}
+		if sep < 6 || sep+1 >= len(fields) {
+			continue
+		}
+		mp := fields[4]
+		inside := mp == "/" || datadir == mp || strings.HasPrefix(datadir, strings.TrimSuffix(mp, "/")+"/")
+		if inside && len(mp) >= len(mountPoint) {
+			device, mountPoint, options, fstype = fields[2], mp, fields[5], fields[sep+1]
+		}
+	}
+	if mountPoint == "" {
+		return
+	}
+	s.addNote("Datadir mount", mountPoint, unitNone, fstype)
+	opts := "," + options + ","
+	if !strings.Contains(opts, ",noatime,") && !strings.Contains(opts, ",relatime,") {
+		s.addNote("Mount options", options, unitNone, "every read updates the access time, mount with noatime")
+	} else {
+		s.add("Mount options", options, unitNone)
+	}
+	// Partitions have no queue of their own, it belongs to the parent disk
+	block := "/sys/dev/block/" + device
+	sched := readFile(block + "/queue/scheduler")
+	rotational := readFile(block + "/queue/rotational")
+	if sched == "" {
+		sched = readFile(block + "/../queue/scheduler")
+		rotational = readFile(block + "/../queue/rotational")
+	}
+	if sched == "" {
+		return
+	}
+	sched = selected(sched)
+	if sched == "cfq" || (sched == "bfq" && rotational == "0") {
+		s.addNote("I/O scheduler", sched, unitNone, "favors fairness over throughput, use none, noop or mq-deadline")
+	} else {
+		s.add("I/O scheduler", sched, unitNone)
+	}
+	if rotational != "" {
+		s.add("Rotational disk", rotational == "1", unitNone)
+	}
+}
+
+// Reads the open files limit of the running mysqld through its pid file.
+func processLimits(s *Section) {
+	pid := readFile(variable["PID_FILE"])
+	if pid == "" {
+		return
+	}
+	f, err := os.Open("/proc/" + pid + "/limits")
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "Max open files") {
+			continue
+		}
+		fields := strings.Fields(strings.TrimPrefix(line, "Max open files"))
+		if len(fields) < 2 {
+			return
+		}
+		soft, _ := strconv.ParseInt(fields[0], 10, 64)
+		wanted, _ := strconv.ParseInt(variable["OPEN_FILES_LIMIT"], 10, 64)
+		if fields[0] != "unlimited" && soft < wanted {
+			s.addNote("Open files limit", soft, unitNone, fmt.Sprintf("lower than open_files_limit (%d)", wanted))
+		} else {
+			s.add("Open files limit", fields[0], unitNone)
+		}
+		return
+	}
+}
+
+func numa(s *Section) {
+	nodes, _ := filepath.Glob("/sys/devices/system/node/node[0-9]*")
+	if len(nodes) == 0 {
+		return
+	}
+	interleave, ok := variable["INNODB_NUMA_INTERLEAVE"]
+	if len(nodes) > 1 && ok && interleave != "ON" {
+		s.addNote("NUMA nodes", len(nodes), unitNone, "memory is allocated on one node first, consider innodb_numa_interleave")
+	} else {
+		s.add("NUMA nodes", len(nodes), unitNone)
+	}
+	if v := readFile("/proc/sys/kernel/numa_balancing"); v != "" {
+		s.add("NUMA balancing", v, unitNone)
+	}
+}
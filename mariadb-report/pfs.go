@@ -0,0 +1,183 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Timers of performance_schema are in picoseconds.
+const picoseconds = 1e12
+
+// Adds the performance_schema sections, or a single line when it is disabled.
+// The sys schema is used when installed for the index checks it provides.
+func performanceSchemaSections(db *sqlx.DB, r *Report, top int) {
+	if variable["PERFORMANCE_SCHEMA"] != "ON" {
+		r.section("Performance schema").addNote("Enabled", "OFF", unitNone, "statement, wait and index insights skipped")
+		return
+	}
+	var sys int
+	db.Get(&sys, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name = 'sys'")
+	topStatements(db, r.section("Top statements by latency"), top)
+	topWaits(db, r.section("Top waits"), top)
+	fullScans(db, r.section("Full table scans"), top)
+	unusedIndexes(db, r.section("Unused indexes"), top, sys > 0)
+	redundantIndexes(db, r.section("Redundant indexes"), top, sys > 0)
+	fileIO(db, r.section("File I/O"), top)
+}
+
+func seconds(timer uint64) float64 {
+	return round(float64(timer)/picoseconds, 3)
+}
+
+func topStatements(db *sqlx.DB, s *Section, top int) {
+	type statement struct {
+		Schema   sql.NullString
+		Digest   sql.NullString
+		Count    int64
+		Latency  uint64
+		Examined int64
+		Sent     int64
+		NoIndex  int64
+	}
+	rows := []statement{}
+	err := db.Select(&rows, `SELECT schema_name AS `+"`schema`"+`, digest_text AS digest, count_star AS count, sum_timer_wait AS latency,
+		sum_rows_examined AS examined, sum_rows_sent AS sent, sum_no_index_used AS noindex
+		FROM performance_schema.events_statements_summary_by_digest ORDER BY sum_timer_wait DESC LIMIT ?`, top)
+	if err != nil {
+		s.add("Statements", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	for i, st := range rows {
+		note := fmt.Sprintf("%d calls, %d rows examined, %d sent", st.Count, st.Examined, st.Sent)
+		if st.NoIndex > 0 {
+			note += fmt.Sprintf(", %d without index", st.NoIndex)
+		}
+		if st.Schema.String != "" {
+			note += ", db " + st.Schema.String
+		}
+		s.addNote(fmt.Sprintf("#%d %s", i+1, oneLine(st.Digest.String, 80)), seconds(st.Latency), unitSeconds, note)
+	}
+}
+
+func topWaits(db *sqlx.DB, s *Section, top int) {
+	type wait struct {
+		Event   string
+		Count   int64
+		Latency uint64
+	}
+	rows := []wait{}
+	err := db.Select(&rows, `SELECT event_name AS event, count_star AS count, sum_timer_wait AS latency
+		FROM performance_schema.events_waits_summary_global_by_event_name
+		WHERE event_name != 'idle' AND sum_timer_wait > 0 ORDER BY sum_timer_wait DESC LIMIT ?`, top)
+	if err != nil {
+		s.add("Waits", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	if len(rows) == 0 {
+		s.addNote("Waits", "None", unitNone, "wait instruments are probably disabled in setup_instruments")
+	}
+	for _, w := range rows {
+		s.addNote(w.Event, seconds(w.Latency), unitSeconds, fmt.Sprintf("%d waits", w.Count))
+	}
+}
+
+func fullScans(db *sqlx.DB, s *Section, top int) {
+	type scan struct {
+		Schema  string
+		Table   string
+		Reads   int64
+		Latency uint64
+	}
+	rows := []scan{}
+	err := db.Select(&rows, `SELECT object_schema AS `+"`schema`"+`, object_name AS `+"`table`"+`, count_read AS reads, sum_timer_read AS latency
+		FROM performance_schema.table_io_waits_summary_by_index_usage
+		WHERE index_name IS NULL AND count_read > 0 AND object_schema NOT IN (`+systemSchemas+`)
+		ORDER BY count_read DESC LIMIT ?`, top)
+	if err != nil {
+		s.add("Tables", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	if len(rows) == 0 {
+		s.add("Tables", "None", unitNone)
+	}
+	for _, t := range rows {
+		s.addNote(t.Schema+"."+t.Table, t.Reads, unitNone, fmt.Sprintf("rows read without index in %s", formatItem(Item{Value: seconds(t.Latency), Unit: unitSeconds})))
+	}
+}
+
+// Lists the indexes never used since startup, from sys when installed.
+func unusedIndexes(db *sqlx.DB, s *Section, top int, sys bool) {
+	query := `SELECT CONCAT(object_schema, '.', object_name, '.', index_name) FROM performance_schema.table_io_waits_summary_by_index_usage
+		WHERE index_name IS NOT NULL AND index_name != 'PRIMARY' AND count_star = 0 AND object_schema NOT IN (` + systemSchemas + `)
+		ORDER BY object_schema, object_name, index_name`
+	if sys {
+		query = "SELECT CONCAT(object_schema, '.', object_name, '.', index_name) FROM sys.schema_unused_indexes ORDER BY object_schema, object_name, index_name"
+	}
+	var names []string
+	if err := db.Select(&names, query); err != nil {
+		s.add("Indexes", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	s.addNote("Indexes", len(names), unitNone, nameList(names, top))
+}
+
+// Redundant indexes are only available from sys, finding them needs the
+// index definitions which performance_schema does not have.
+func redundantIndexes(db *sqlx.DB, s *Section, top int, sys bool) {
+	if !sys {
+		s.addNote("Indexes", "skipped", unitNone, "needs the sys schema")
+		return
+	}
+	type redundant struct {
+		Schema    string
+		Table     string
+		Redundant string
+		Dominant  string
+	}
+	rows := []redundant{}
+	err := db.Select(&rows, "SELECT table_schema AS `schema`, table_name AS `table`, redundant_index_name AS redundant, dominant_index_name AS dominant FROM sys.schema_redundant_indexes ORDER BY table_schema, table_name")
+	if err != nil {
+		s.add("Indexes", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	var names []string
+	for _, r := range rows {
+		names = append(names, fmt.Sprintf("%s.%s.%s (covered by %s)", r.Schema, r.Table, r.Redundant, r.Dominant))
+	}
+	s.addNote("Indexes", len(names), unitNone, nameList(names, top))
+}
+
+func fileIO(db *sqlx.DB, s *Section, top int) {
+	type file struct {
+		Name    string
+		Read    int64
+		Written int64
+		Latency uint64
+	}
+	rows := []file{}
+	err := db.Select(&rows, `SELECT file_name AS name, sum_number_of_bytes_read AS `+"`read`"+`, sum_number_of_bytes_write AS written, sum_timer_wait AS latency
+		FROM performance_schema.file_summary_by_instance WHERE sum_timer_wait > 0 ORDER BY sum_timer_wait DESC LIMIT ?`, top)
+	if err != nil {
+		s.add("Files", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	if len(rows) == 0 {
+		s.add("Files", "None", unitNone)
+	}
+	for _, f := range rows {
+		note := fmt.Sprintf("read %s, written %s", formatItem(Item{Value: f.Read, Unit: unitBytes}), formatItem(Item{Value: f.Written, Unit: unitBytes}))
+		s.addNote(strings.TrimPrefix(f.Name, variable["DATADIR"]), seconds(f.Latency), unitSeconds, note)
+	}
+}
+
+// Squeezes whitespace and shortens a statement to max characters.
+func oneLine(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		return string(r[:max-3]) + "..."
+	}
+	return s
+}
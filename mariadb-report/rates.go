@@ -0,0 +1,73 @@
+package main
+
+import (
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Length of the sampling window, zero when the rates are lifetime averages.
+var windowElapsed float64
+
+// Samples the status twice, window apart. The first sample is kept in
+// prevStatus and the second replaces status.
+func sampleWindow(db *sqlx.DB, window time.Duration) {
+	prevStatus = status
+	start := time.Now()
+	time.Sleep(window)
+	status = dbhelper.GetStatusAsInt(db)
+	windowElapsed = time.Since(start).Seconds()
+}
+
+// Returns the per second rate of a counter, over the sampling window when
+// there is one, since startup otherwise.
+func rate(name string) float64 {
+	if windowElapsed > 0 {
+		return float64(status[name]-prevStatus[name]) / windowElapsed
+	}
+	return lifetimeRate(name)
+}
+
+func lifetimeRate(name string) float64 {
+	if status["UPTIME"] == 0 {
+		return 0
+	}
+	return float64(status[name]) / float64(status["UPTIME"])
+}
+
+// Adds the rates section, comparing the current rates with the averages since
+// startup when a sampling window is used.
+func ratesSection(s *Section) {
+	metrics := []struct {
+		name     string
+		counters []string
+		unit     string
+	}{
+		{"Queries", []string{"QUESTIONS"}, unitPerSecond},
+		{"Binlog writes", []string{"BINLOG_BYTES_WRITTEN"}, unitBytesPerHour},
+		{"InnoDB log writes", []string{"INNODB_OS_LOG_WRITTEN"}, unitBytesPerHour},
+		{"Rows read", []string{"INNODB_ROWS_READ"}, unitPerSecond},
+		{"Rows changed", []string{"INNODB_ROWS_INSERTED", "INNODB_ROWS_UPDATED", "INNODB_ROWS_DELETED"}, unitPerSecond},
+		{"Temporary tables", []string{"CREATED_TMP_TABLES"}, unitPerSecond},
+		{"Temporary tables on disk", []string{"CREATED_TMP_DISK_TABLES"}, unitPerSecond},
+	}
+	if windowElapsed > 0 {
+		s.add("Sampling window", round(windowElapsed, 1), unitSeconds)
+	}
+	for _, m := range metrics {
+		var current, lifetime float64
+		for _, c := range m.counters {
+			current += rate(c)
+			lifetime += lifetimeRate(c)
+		}
+		if m.unit == unitBytesPerHour {
+			current, lifetime = current*3600, lifetime*3600
+		}
+		if windowElapsed == 0 {
+			s.addNote(m.name, round(lifetime, 1), m.unit, "average since startup")
+			continue
+		}
+		s.addNote(m.name, round(current, 1), m.unit, "average since startup "+formatItem(Item{Value: round(lifetime, 1), Unit: m.unit}))
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/tanji/mariadb-tools/common"
+)
+
+func render(w io.Writer, r *Report, format string) error {
+	switch format {
+	case "text":
+		renderText(w, r)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	case "markdown":
+		renderMarkdown(w, r)
+	default:
+		return fmt.Errorf("unknown output format %s, must be text, json or markdown", format)
+	}
+	return nil
+}
+
+func renderText(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "### MariaDB Server report for host %s\n", r.Host)
+	fmt.Fprintf(w, "### %-25s%s\n", "Kernel version", r.Kernel)
+	fmt.Fprintf(w, "### %-25s%s\n", "System Time", r.Time.Format("2006-01-02 at 03:04 (MST)"))
+	for _, s := range r.Sections {
+		fmt.Fprintln(w, common.DrawHashline(s.Name, 60))
+		for _, i := range s.Items {
+			fmt.Fprintf(w, "    %-25s%-20s\n", i.Name, formatItem(i))
+		}
+	}
+}
+
+func renderMarkdown(w io.Writer, r *Report) {
+	fmt.Fprintf(w, "# MariaDB Server report for host %s\n\n", r.Host)
+	fmt.Fprintf(w, "* Kernel version: %s\n", r.Kernel)
+	fmt.Fprintf(w, "* System time: %s\n", r.Time.Format(time.RFC1123))
+	// Both sides of a diff get their own column
+	diff := false
+	for _, s := range r.Sections {
+		for _, i := range s.Items {
+			diff = diff || i.Other != nil
+		}
+	}
+	for _, s := range r.Sections {
+		fmt.Fprintf(w, "\n## %s\n\n", s.Name)
+		if !diff {
+			fmt.Fprintln(w, "| Item | Value |")
+			fmt.Fprintln(w, "| --- | --- |")
+			for _, i := range s.Items {
+				fmt.Fprintf(w, "| %s | %s |\n", escapeMarkdown(i.Name), escapeMarkdown(formatItem(i)))
+			}
+			continue
+		}
+		fmt.Fprintln(w, "| Item | Left | Right |")
+		fmt.Fprintln(w, "| --- | --- | --- |")
+		for _, i := range s.Items {
+			var right string
+			if i.Other != nil {
+				right = formatItem(Item{Value: i.Other, Unit: i.Unit})
+			}
+			i.Other = nil
+			fmt.Fprintf(w, "| %s | %s | %s |\n", escapeMarkdown(i.Name), escapeMarkdown(formatItem(i)), escapeMarkdown(right))
+		}
+	}
+}
+
+func escapeMarkdown(s string) string {
+	return strings.NewReplacer("|", "\\|", "\n", " ").Replace(s)
+}
+
+// Returns the human form of an item value according to its unit.
+func formatItem(i Item) string {
+	var s string
+	switch i.Unit {
+	case unitBytes:
+		if f, ok := toFloat(i.Value); ok {
+			s = humanize.IBytes(uint64(f))
+		}
+	case unitPercent:
+		if f, ok := toFloat(i.Value); ok {
+			s = strconv.FormatFloat(f, 'f', -1, 64) + "%"
+		}
+	case unitBytesPerHour:
+		if f, ok := toFloat(i.Value); ok {
+			s = humanize.IBytes(uint64(f)) + "/h"
+		}
+	case unitSeconds:
+		if f, ok := toFloat(i.Value); ok {
+			s = strconv.FormatFloat(f, 'f', -1, 64) + "s"
+		}
+	case unitPerSecond:
+		if f, ok := toFloat(i.Value); ok {
+			s = strconv.FormatFloat(f, 'f', -1, 64) + "/s"
+		}
+	case unitTime:
+		if t, err := time.Parse(time.RFC3339, fmt.Sprint(i.Value)); err == nil {
+			s = humanize.Time(t)
+		}
+	}
+	if s == "" {
+		s = formatValue(i.Value)
+	}
+	if i.Other != nil {
+		s += " | " + formatItem(Item{Value: i.Other, Unit: i.Unit})
+	}
+	if i.Note != "" {
+		s += " - " + i.Note
+	}
+	return s
+}
+
+func formatValue(v interface{}) string {
+	if v == nil {
+		return "n/a"
+	}
+	if f, ok := v.(float64); ok {
+		return strconv.FormatFloat(f, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// Converts the numeric value types of a report, json.Number included for
+// reports read back from a file.
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/common"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Adds the replication section, describing the primary side of the server,
+// and one section per replication channel.
+func replicationSections(db *sqlx.DB, r *Report) {
+	s := r.section("Replication")
+	channels, err := dbhelper.GetAllSlavesStatus(db)
+	if err != nil {
+		// Not MariaDB, or no multi-source support: fall back to the default channel
+		var ss dbhelper.SlaveStatus
+		ss, err = dbhelper.GetSlaveStatus(db)
+		channels = nil
+		if err == nil {
+			channels = append(channels, ss)
+		}
+	}
+	if err != nil && err != sql.ErrNoRows {
+		s.add("Replica status", "Unavailable: "+err.Error(), unitNone)
+	}
+	var replicas []dbhelper.SlaveHosts
+	var replicasErr error
+	if variable["LOG_BIN"] == "ON" {
+		// SHOW SLAVE HOSTS needs the REPLICATION MASTER ADMIN privilege
+		replicas, replicasErr = dbhelper.GetSlaveHostsArrayErr(db)
+	}
+	var roles []string
+	if len(replicas) > 0 {
+		roles = append(roles, "primary")
+	}
+	if len(channels) > 0 {
+		roles = append(roles, "replica")
+	}
+	if len(roles) == 0 {
+		roles = append(roles, "standalone")
+	}
+	s.add("Role", strings.Join(roles, ", "), unitNone)
+	s.add("Server id", common.StrtoInt(variable["SERVER_ID"]), unitNone)
+	if variable["LOG_BIN"] == "ON" {
+		s.add("Binlog format", variable["BINLOG_FORMAT"], unitNone)
+		s.add("Sync binlog", common.StrtoInt(variable["SYNC_BINLOG"]), unitNone)
+		if ms, err := dbhelper.GetMasterStatus(db); err == nil {
+			s.add("Binlog position", fmt.Sprintf("%s:%d", ms.File, ms.Position), unitNone)
+		}
+	}
+	if v, ok := variable["GTID_BINLOG_POS"]; ok {
+		s.add("GTID binlog pos", v, unitNone)
+		s.add("GTID current pos", variable["GTID_CURRENT_POS"], unitNone)
+		s.add("GTID strict mode", variable["GTID_STRICT_MODE"], unitNone)
+	} else if v, ok := variable["GTID_EXECUTED"]; ok {
+		s.add("GTID executed", v, unitNone)
+	}
+	if replicasErr != nil {
+		s.add("Connected replicas", "Unavailable: "+replicasErr.Error(), unitNone)
+	} else {
+		s.add("Connected replicas", len(replicas), unitNone)
+	}
+	for _, h := range replicas {
+		s.add(fmt.Sprintf("Replica %d", h.Server_id), fmt.Sprintf("%s:%d", h.Host, h.Port), unitNone)
+	}
+	if v, ok := variable["RPL_SEMI_SYNC_MASTER_ENABLED"]; ok {
+		s.addNote("Semi-sync primary", v, unitNone, fmt.Sprintf("%d clients, %d acked, %d not acked", status["RPL_SEMI_SYNC_MASTER_CLIENTS"], status["RPL_SEMI_SYNC_MASTER_YES_TX"], status["RPL_SEMI_SYNC_MASTER_NO_TX"]))
+	}
+	if len(channels) == 0 {
+		return
+	}
+	s.add("GTID slave pos", variable["GTID_SLAVE_POS"], unitNone)
+	s.add("Read only", variable["READ_ONLY"], unitNone)
+	if v, ok := variable["RPL_SEMI_SYNC_SLAVE_ENABLED"]; ok {
+		s.add("Semi-sync replica", v, unitNone)
+	}
+	if v, ok := variable["SLAVE_PARALLEL_THREADS"]; ok {
+		s.add("Parallel threads", common.StrtoInt(v), unitNone)
+		s.add("Parallel mode", variable["SLAVE_PARALLEL_MODE"], unitNone)
+		s.add("Domain parallel threads", common.StrtoInt(variable["SLAVE_DOMAIN_PARALLEL_THREADS"]), unitNone)
+	} else if v, ok := variable["SLAVE_PARALLEL_WORKERS"]; ok {
+		s.add("Parallel workers", common.StrtoInt(v), unitNone)
+	}
+	for _, c := range channels {
+		channelSection(r, c)
+	}
+}
+
+func channelSection(r *Report, c dbhelper.SlaveStatus) {
+	name := c.Connection_name
+	if name == "" {
+		name = "default"
+	}
+	s := r.section("Replica channel " + name)
+	s.add("Primary", fmt.Sprintf("%s:%d", c.Master_Host, c.Master_Port), unitNone)
+	s.add("IO thread", c.Slave_IO_Running, unitNone)
+	s.add("SQL thread", c.Slave_SQL_Running, unitNone)
+	var lag interface{}
+	if c.Seconds_Behind_Master.Valid {
+		lag = c.Seconds_Behind_Master.Int64
+	}
+	s.add("Seconds behind primary", lag, unitNone)
+	s.add("Using GTID", c.Using_Gtid, unitNone)
+	if c.Gtid_IO_Pos != "" {
+		s.add("GTID IO pos", c.Gtid_IO_Pos, unitNone)
+	}
+	s.add("Read position", fmt.Sprintf("%s:%d", c.Master_Log_File, c.Read_Master_Log_Pos), unitNone)
+	s.add("Exec position", fmt.Sprintf("%s:%d", c.Relay_Master_Log_File, c.Exec_Master_Log_Pos), unitNone)
+	s.add("Relay log space", uint64(c.Relay_Log_Space), unitBytes)
+	if c.Last_IO_Errno != 0 {
+		s.addNote("Last IO error", int64(c.Last_IO_Errno), unitNone, c.Last_IO_Error)
+	}
+	if c.Last_SQL_Errno != 0 {
+		s.addNote("Last SQL error", int64(c.Last_SQL_Errno), unitNone, c.Last_SQL_Error)
+	}
+	filters := []struct{ name, value string }{
+		{"Replicate do db", c.Replicate_Do_DB},
+		{"Replicate ignore db", c.Replicate_Ignore_DB},
+		{"Replicate do table", c.Replicate_Do_Table},
+		{"Replicate ignore table", c.Replicate_Ignore_Table},
+		{"Replicate wild do", c.Replicate_Wild_Do_Table},
+		{"Replicate wild ignore", c.Replicate_Wild_Ignore_Table},
+		{"Ignore server ids", c.Replicate_Ignore_Server_Ids},
+	}
+	for _, f := range filters {
+		if f.value != "" {
+			s.add(f.name, f.value, unitNone)
+		}
+	}
+	s.add("Retried transactions", int64(c.Retried_transactions), unitNone)
+}
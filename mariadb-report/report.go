@@ -4,15 +4,13 @@ import (
 	_ "database/sql"
 	"flag"
 	"fmt"
-	"github.com/dustin/go-humanize"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 	"github.com/tanji/mariadb-tools/common"
 	"github.com/tanji/mariadb-tools/dbhelper"
 	"log"
 	"os"
-	"os/exec"
-	"strconv"
+	"strings"
 	"time"
 )
 
@@ -20,18 +18,21 @@ var status map[string]int64
 var prevStatus map[string]int64
 var variable map[string]string
 
-type timeSeries struct {
-	Name    string
-	Columns []string
-	Points  [][]int64
-}
-
 var version = flag.Bool("version", false, "Return version")
 var user = flag.String("user", "", "User for MariaDB login")
 var password = flag.String("password", "", "Password for MariaDB login")
 var host = flag.String("host", "", "MariaDB host IP address or FQDN")
 var socket = flag.String("socket", "/var/run/mysqld/mysqld.sock", "Path of MariaDB unix socket")
 var port = flag.String("port", "3306", "TCP Port of MariaDB server")
+var rulesFile = flag.String("rules", "", "Ini file of advisor rules evaluated in addition to the builtin rules")
+var schemaLimit = flag.Int("schema-limit", 20000, "Skip the table sizes of the schema section above this number of tables (0 disables them)")
+var top = flag.Int("top", 10, "Number of entries listed in the schema, security and performance schema sections")
+var diff = flag.String("diff", "", "Compare the variables of two sources instead of reporting, as a,b where each source is a JSON report file or a live host[:port]")
+var diffStatus = flag.Bool("diff-status", false, "Also compare the status counters as rates per second since startup")
+var diffThreshold = flag.Float64("diff-threshold", 0.5, "Relative difference over which status rates are reported")
+var diffIgnore = flag.String("diff-ignore", "", "Comma separated variable patterns ignored by the diff, in addition to server_id, hostname, gtid positions and file names")
+var window = flag.Duration("window", 0, "Sample the status twice over this window, e.g. 60s, and report current rates instead of averages since startup")
+var format = flag.String("format", "text", "Output format: text, json or markdown")
 
 func main() {
 
@@ -40,6 +41,24 @@ func main() {
 		fmt.Println("MariaDB Tools version 0.0.1")
 		os.Exit(0)
 	}
+	if *format != "text" && *format != "json" && *format != "markdown" {
+		log.Fatalf("ERROR: Unknown output format %s, must be text, json or markdown", *format)
+	}
+	if *top < 0 {
+		log.Fatalln("ERROR: -top cannot be negative")
+	}
+	rules := loadBuiltinRules()
+	if *rulesFile != "" {
+		userRules, err := loadRules(*rulesFile)
+		if err != nil {
+			log.Fatalln("ERROR: Could not load rules", err)
+		}
+		rules = append(rules, userRules...)
+	}
+	if *diff != "" {
+		runDiff()
+		return
+	}
 	var address string
 	if *socket != "" {
 		address = "unix(" + *socket + ")"
@@ -58,96 +77,115 @@ func main() {
 
 	status = dbhelper.GetStatusAsInt(db)
 	variable, _ = dbhelper.GetVariables(db)
+	if *window > 0 {
+		log.Printf("Sampling status for %s", *window)
+		sampleWindow(db, *window)
+	}
 
-	out, err := exec.Command("uname", "-srm").Output()
-	if err != nil {
-		log.Fatal(err)
+	hostname := variable["HOSTNAME"]
+	if hostname == "" {
+		hostname, _ = os.Hostname()
+	}
+	r := &Report{Host: hostname, Kernel: kernelVersion(), Time: time.Now(), Variables: variable, Status: status}
+	generalSection(db, r.section("General"))
+	ratesSection(r.section("Rates"))
+	osSection(r.section("Operating system"))
+	replicationSections(db, r)
+	galeraSection(db, r)
+	innodbSection(r.section("InnoDB"))
+	myisamSection(r.section("MyISAM"))
+	schemaSection(db, r.section("Schema"), *schemaLimit, *top)
+	securitySection(db, r.section("Security"), *top)
+	performanceSchemaSections(db, r, *top)
+	env := &ruleEnv{status: status, variables: variable, system: systemValues()}
+	advisorSection(r.section("Advisor"), advise(rules, env))
+	if err = render(os.Stdout, r, *format); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+func runDiff() {
+	sources := strings.Split(*diff, ",")
+	if len(sources) != 2 {
+		log.Fatalln("ERROR: -diff needs two sources separated by a comma")
+	}
+	var sides [2]*diffSource
+	for i, arg := range sources {
+		src, err := loadDiffSource(strings.TrimSpace(arg))
+		if err != nil {
+			log.Fatalln("ERROR: Could not read", arg, err)
+		}
+		sides[i] = src
+	}
+	var extra []string
+	if *diffIgnore != "" {
+		extra = strings.Split(*diffIgnore, ",")
 	}
-	hostname, _ := os.Hostname()
-	fmt.Printf("### MariaDB Server report for host %s\n", hostname)
-	fmt.Printf("### %-25s%s", "Kernel version", out)
-	fmt.Printf("### %-25s%s\n", "System Time", time.Now().Format("2006-01-02 at 03:04 (MST)"))
-	fmt.Println(common.DrawHashline("General", 60))
+	r := diffReport(sides[0], sides[1], extra, *diffStatus, *diffThreshold)
+	if err := render(os.Stdout, r, *format); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+func generalSection(db *sqlx.DB, s *Section) {
 	var server_version string
 	db.QueryRow("SELECT VERSION()").Scan(&server_version)
-	pPrintStr("Version", server_version)
+	s.add("Version", server_version, unitNone)
 	now := time.Now().Unix()
 	uptime := status["UPTIME"]
 	start_time := time.Unix(now-uptime, 0).Local()
-	pPrintStr("Started", humanize.Time(start_time))
+	s.add("Started", start_time.Format(time.RFC3339), unitTime)
 	var count int64
-	db.Get(&count, "SELECT COUNT(*) FROM information_schema.schemata")
-	pPrintInt("Databases", count)
-	db.Get(&count, "SELECT COUNT(*) FROM information_schema.tables")
-	pPrintInt("Tables", count) /* Potentially unsafe for large systems */
-	pPrintStr("Datadir", variable["DATADIR"])
-	pPrintStr("Binary Log", variable["LOG_BIN"])
-	if variable["LOG_BIN"] == "ON" {
-		pPrintStr("Binlog writes per hour", humanize.IBytes(uint64(status["BINLOG_BYTES_WRITTEN"]/status["UPTIME"])*3600))
-	}
-	// Add stuff for slow logs
-	slaveStatus, err := dbhelper.GetSlaveStatus(db)
-	if err != nil {
-		slaveIO := slaveStatus.Slave_IO_Running
-		slaveSQL := slaveStatus.Slave_SQL_Running
-		var slaveState string
-		if slaveIO == "Yes" && slaveSQL == "Yes" {
-			slaveState = "Slave configured, threads running"
-		} else {
-			slaveState = "Slave configured, threads stopped"
-		}
-		pPrintStr("Replication", slaveState)
+	db.Get(&count, "SELECT COUNT(*) FROM information_schema.schemata WHERE schema_name NOT IN ("+systemSchemas+")")
+	s.add("Databases", count, unitNone)
+	if count, err := countTables(db); err != nil {
+		s.add("Tables", "Unavailable: "+err.Error(), unitNone)
 	} else {
-		pPrintStr("Replication", "Not configured")
+		s.add("Tables", count, unitNone)
+	}
+	s.add("Datadir", variable["DATADIR"], unitNone)
+	s.add("Binary Log", variable["LOG_BIN"], unitNone)
+	if variable["LOG_BIN"] == "ON" {
+		s.add("Binlog writes per hour", int64(rate("BINLOG_BYTES_WRITTEN")*3600), unitBytes)
 	}
+}
 
-	// InnoDB
-	fmt.Println(common.DrawHashline("InnoDB", 60))
-	ibps := humanize.IBytes(common.StrtoUint(variable["INNODB_BUFFER_POOL_SIZE"]))
-	pPrintStr("InnoDB Buffer Pool", ibps)
+func innodbSection(s *Section) {
+	s.add("InnoDB Buffer Pool", common.StrtoUint(variable["INNODB_BUFFER_POOL_SIZE"]), unitBytes)
 	ibpsPages := float64(status["INNODB_BUFFER_POOL_PAGES_TOTAL"])
 	ibpsFree := float64(status["INNODB_BUFFER_POOL_PAGES_FREE"])
-	ibpsUsed := common.DecimaltoPctLow(ibpsFree, ibpsPages)
-	pPrintStr("InnoDB Buffer Used", strconv.Itoa(ibpsUsed)+"%")
+	s.add("InnoDB Buffer Used", common.DecimaltoPctLow(ibpsFree, ibpsPages), unitPercent)
 	ibpsDirty := float64(status["INNODB_BUFFER_POOL_PAGES_DIRTY"])
-	ibpsDirtyPct := common.DecimaltoPct(ibpsDirty, ibpsPages)
-	pPrintStr("InnoDB Buffer Dirty", strconv.Itoa(ibpsDirtyPct)+"%")
-	pPrintStr("InnoDB Log Files", string(variable["INNODB_LOG_FILES_IN_GROUP"])+" files of "+humanize.IBytes(common.StrtoUint(variable["INNODB_LOG_FILE_SIZE"])))
-	pPrintStr("InnoDB log writes per hour", humanize.IBytes(uint64(status["INNODB_OS_LOG_WRITTEN"]/status["UPTIME"])*3600))
-	pPrintStr("InnoDB Log Buffer", humanize.IBytes(common.StrtoUint(variable["INNODB_LOG_BUFFER_SIZE"])))
+	s.add("InnoDB Buffer Dirty", common.DecimaltoPct(ibpsDirty, ibpsPages), unitPercent)
+	s.add("InnoDB Log Files", common.StrtoInt(variable["INNODB_LOG_FILES_IN_GROUP"]), unitNone)
+	s.add("InnoDB Log File Size", common.StrtoUint(variable["INNODB_LOG_FILE_SIZE"]), unitBytes)
+	s.add("InnoDB log writes per hour", int64(rate("INNODB_OS_LOG_WRITTEN")*3600), unitBytes)
+	s.add("InnoDB Log Buffer", common.StrtoUint(variable["INNODB_LOG_BUFFER_SIZE"]), unitBytes)
 	var iftc string
 	switch variable["INNODB_FLUSH_LOG_AT_TRX_COMMIT"] {
 	case "0":
-		iftc = "0 - Flush log and write buffer every sec"
+		iftc = "Flush log and write buffer every sec"
 	case "1":
-		iftc = "1 - Write buffer and Flush log at each trx commit"
+		iftc = "Write buffer and Flush log at each trx commit"
 	case "2":
-		iftc = "2 - Write buffer at each trx commit, Flush log every sec"
+		iftc = "Write buffer at each trx commit, Flush log every sec"
 	}
-	pPrintStr("InnoDB Flush Log", iftc)
+	s.addNote("InnoDB Flush Log", variable["INNODB_FLUSH_LOG_AT_TRX_COMMIT"], unitNone, iftc)
 	ifm := variable["INNODB_FLUSH_METHOD"]
 	if ifm == "" {
 		ifm = "fsync"
 	}
-	pPrintStr("InnoDB Flush Method", ifm)
-	pPrintStr("InnoDB IO Capacity", variable["INNODB_IO_CAPACITY"])
-	// MyISAM
-	fmt.Println(common.DrawHashline("MyISAM", 60))
-	kbs := humanize.IBytes(common.StrtoUint(variable["KEY_BUFFER_SIZE"]))
-	pPrintStr("MyISAM Key Cache", kbs)
+	s.add("InnoDB Flush Method", ifm, unitNone)
+	s.add("InnoDB IO Capacity", common.StrtoInt(variable["INNODB_IO_CAPACITY"]), unitNone)
+}
+
+func myisamSection(s *Section) {
+	s.add("MyISAM Key Cache", common.StrtoUint(variable["KEY_BUFFER_SIZE"]), unitBytes)
 	kbs_free := float64(status["KEY_BLOCKS_UNUSED"])
 	kbs_used := float64(status["KEY_BLOCKS_USED"])
 	kbsUsedPct := int(((1 - (kbs_free / (kbs_free + kbs_used))) * 100) + 0.5)
-	pPrintStr("MyISAM Cache Used", strconv.Itoa(kbsUsedPct)+"%")
+	s.add("MyISAM Cache Used", kbsUsedPct, unitPercent)
 	// Handlers
-	pPrintInt("Open tables", status["OPEN_TABLES"])
-	pPrintInt("Open files", status["OPEN_FILES"])
-}
-
-func pPrintStr(name string, value string) {
-	fmt.Printf("    %-25s%-20s\n", name, value)
-}
-
-func pPrintInt(name string, value int64) {
-	fmt.Printf("    %-25s%-20d\n", name, value)
+	s.add("Open tables", status["OPEN_TABLES"], unitNone)
+	s.add("Open files", status["OPEN_FILES"], unitNone)
 }
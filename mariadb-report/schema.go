@@ -0,0 +1,259 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const systemSchemas = "'mysql', 'information_schema', 'performance_schema', 'sys'"
+
+type tableInfo struct {
+	Schema        string
+	Name          string
+	Engine        sql.NullString
+	Rows          sql.NullInt64
+	Data          sql.NullInt64
+	Index         sql.NullInt64
+	Free          sql.NullInt64
+	AutoIncrement sql.NullFloat64
+}
+
+func (t tableInfo) size() int64 {
+	return t.Data.Int64 + t.Index.Int64
+}
+
+func (t tableInfo) fullName() string {
+	return t.Schema + "." + t.Name
+}
+
+// Maximum values of the integer types, used to find auto-increment columns
+// close to overflow.
+var intMax = map[string]float64{
+	"tinyint":   127,
+	"smallint":  32767,
+	"mediumint": 8388607,
+	"int":       2147483647,
+	"bigint":    9223372036854775807,
+}
+
+// Adds the schema section. Reading table sizes opens every table, so the
+// detailed part is only run when the number of tables is below the limit.
+func schemaSection(db *sqlx.DB, s *Section, limit int, top int) {
+	count, err := countTables(db)
+	if err != nil {
+		s.add("Details", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	if limit == 0 {
+		s.addNote("Details", "skipped", unitNone, "disabled by -schema-limit 0")
+		return
+	}
+	if count > int64(limit) {
+		s.addNote("Details", "skipped", unitNone, fmt.Sprintf("%d tables is over -schema-limit %d", count, limit))
+		return
+	}
+	tables := []tableInfo{}
+	err = db.Select(&tables, `SELECT table_schema AS `+"`schema`"+`, table_name AS name, engine, table_rows AS `+"`rows`"+`,
+		data_length AS data, index_length AS `+"`index`"+`, data_free AS free, auto_increment AS autoincrement
+		FROM information_schema.tables WHERE table_type = 'BASE TABLE' AND table_schema NOT IN (`+systemSchemas+`)`)
+	if err != nil {
+		s.add("Details", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	var total int64
+	for _, t := range tables {
+		total += t.size()
+	}
+	s.add("Total size", total, unitBytes)
+	databaseSizes(s, tables, top)
+	engineDistribution(s, tables)
+	sort.Slice(tables, func(i, j int) bool { return tables[i].size() > tables[j].size() })
+	for i, t := range tables {
+		if i >= top {
+			break
+		}
+		s.addNote("Table "+t.fullName(), t.size(), unitBytes, fmt.Sprintf("%s, %d rows", t.Engine.String, t.Rows.Int64))
+	}
+	noPrimaryKey(db, s, tables, top)
+	if strings.EqualFold(variable["DEFAULT_STORAGE_ENGINE"], "InnoDB") {
+		var names []string
+		for _, t := range tables {
+			if t.Engine.String == "MyISAM" || t.Engine.String == "Aria" {
+				names = append(names, t.fullName())
+			}
+		}
+		s.addNote("MyISAM/Aria tables", len(names), unitNone, nameList(names, top))
+	}
+	// data_free of tables in the shared tablespace is the free space of ibdata,
+	// it is reported once rather than for each of these tables
+	shared := sharedTablespaceTables(db)
+	var fragmented []string
+	systemFree := int64(-1)
+	for _, t := range tables {
+		if t.Engine.String == "InnoDB" && shared(t) {
+			systemFree = t.Free.Int64
+			continue
+		}
+		if t.Free.Int64 > 100<<20 && t.Free.Int64 > t.size()/5 {
+			fragmented = append(fragmented, t.fullName())
+		}
+	}
+	s.addNote("Fragmented tables", len(fragmented), unitNone, nameList(fragmented, top))
+	if systemFree >= 0 {
+		s.addNote("System tablespace free", systemFree, unitBytes, "shared by the tables stored in ibdata")
+	}
+	autoIncrementOverflow(db, s, tables, top)
+}
+
+// Returns the number of tables outside the system schemas. Only the name
+// columns are read, which does not open the tables. Views are told apart
+// through information_schema.views, not table_type which needs the table
+// definitions.
+func countTables(db *sqlx.DB) (int64, error) {
+	var count int64
+	err := db.Get(&count, "SELECT (SELECT COUNT(*) FROM information_schema.tables WHERE table_schema NOT IN ("+systemSchemas+")) - "+
+		"(SELECT COUNT(*) FROM information_schema.views WHERE table_schema NOT IN ("+systemSchemas+"))")
+	return count, err
+}
+
+// Returns whether an InnoDB table is stored in the system tablespace. The
+// tablespace ids come from INNODB_SYS_TABLES, or INNODB_TABLES on MySQL 8;
+// when neither is readable innodb_file_per_table is used as a best guess.
+func sharedTablespaceTables(db *sqlx.DB) func(tableInfo) bool {
+	var names []string
+	err := db.Select(&names, "SELECT name FROM information_schema.innodb_sys_tables WHERE space = 0")
+	if err != nil {
+		err = db.Select(&names, "SELECT name FROM information_schema.innodb_tables WHERE space = 0")
+	}
+	if err != nil {
+		perTable := variable["INNODB_FILE_PER_TABLE"] == "ON"
+		return func(tableInfo) bool { return !perTable }
+	}
+	shared := make(map[string]bool, len(names))
+	for _, n := range names {
+		shared[n] = true
+	}
+	return func(t tableInfo) bool { return shared[t.Schema+"/"+t.Name] }
+}
+
+func databaseSizes(s *Section, tables []tableInfo, top int) {
+	sizes := make(map[string]int64)
+	counts := make(map[string]int)
+	for _, t := range tables {
+		sizes[t.Schema] += t.size()
+		counts[t.Schema]++
+	}
+	names := make([]string, 0, len(sizes))
+	for n := range sizes {
+		names = append(names, n)
+	}
+	sort.Slice(names, func(i, j int) bool { return sizes[names[i]] > sizes[names[j]] })
+	var other int64
+	for i, n := range names {
+		if i >= top {
+			other += sizes[n]
+			continue
+		}
+		var data, index int64
+		for _, t := range tables {
+			if t.Schema == n {
+				data += t.Data.Int64
+				index += t.Index.Int64
+			}
+		}
+		s.addNote("Database "+n, sizes[n], unitBytes, fmt.Sprintf("%d tables, data %s, index %s", counts[n], formatItem(Item{Value: data, Unit: unitBytes}), formatItem(Item{Value: index, Unit: unitBytes})))
+	}
+	if len(names) > top {
+		s.addNote("Other databases", other, unitBytes, fmt.Sprintf("%d databases", len(names)-top))
+	}
+}
+
+func engineDistribution(s *Section, tables []tableInfo) {
+	counts := make(map[string]int)
+	sizes := make(map[string]int64)
+	for _, t := range tables {
+		e := t.Engine.String
+		if e == "" {
+			e = "unknown"
+		}
+		counts[e]++
+		sizes[e] += t.size()
+	}
+	engines := make([]string, 0, len(counts))
+	for e := range counts {
+		engines = append(engines, e)
+	}
+	sort.Strings(engines)
+	for _, e := range engines {
+		s.addNote("Engine "+e, counts[e], unitNone, formatItem(Item{Value: sizes[e], Unit: unitBytes}))
+	}
+}
+
+func noPrimaryKey(db *sqlx.DB, s *Section, tables []tableInfo, top int) {
+	var keyed []string
+	err := db.Select(&keyed, "SELECT CONCAT(table_schema, '.', table_name) FROM information_schema.table_constraints WHERE constraint_type = 'PRIMARY KEY' AND table_schema NOT IN ("+systemSchemas+")")
+	if err != nil {
+		s.add("Tables without PK", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	pk := make(map[string]bool, len(keyed))
+	for _, k := range keyed {
+		pk[k] = true
+	}
+	var names []string
+	for _, t := range tables {
+		if !pk[t.fullName()] {
+			names = append(names, t.fullName())
+		}
+	}
+	sort.Strings(names)
+	s.addNote("Tables without PK", len(names), unitNone, nameList(names, top))
+}
+
+// Reports the auto-increment columns which used more than 75% of their range.
+func autoIncrementOverflow(db *sqlx.DB, s *Section, tables []tableInfo, top int) {
+	type column struct {
+		Schema string
+		Table  string
+		Name   string
+		Type   string
+	}
+	cols := []column{}
+	err := db.Select(&cols, "SELECT table_schema AS `schema`, table_name AS `table`, column_name AS name, column_type AS type FROM information_schema.columns WHERE extra LIKE '%auto_increment%' AND table_schema NOT IN ("+systemSchemas+")")
+	if err != nil {
+		s.add("Auto-increment usage", "Unavailable: "+err.Error(), unitNone)
+		return
+	}
+	next := make(map[string]float64, len(tables))
+	for _, t := range tables {
+		next[t.fullName()] = t.AutoIncrement.Float64
+	}
+	var names []string
+	for _, c := range cols {
+		f := strings.Fields(c.Type)
+		max, ok := intMax[strings.SplitN(f[0], "(", 2)[0]]
+		if !ok {
+			continue
+		}
+		if strings.Contains(c.Type, "unsigned") {
+			max = max*2 + 1
+		}
+		used := next[c.Schema+"."+c.Table] / max
+		if used > 0.75 {
+			names = append(names, fmt.Sprintf("%s.%s.%s %.0f%%", c.Schema, c.Table, c.Name, used*100))
+		}
+	}
+	s.addNote("Auto-increment near max", len(names), unitNone, nameList(names, top))
+}
+
+// Returns the first n names, followed by the number of names left out.
+func nameList(names []string, n int) string {
+	if len(names) <= n {
+		return strings.Join(names, ", ")
+	}
+	return strings.Join(names[:n], ", ") + fmt.Sprintf(" and %d more", len(names)-n)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Authentication plugins which check a password against the hash stored in
+// mysql.user. With an empty hash they accept an empty password.
+var passwordPlugins = map[string]bool{
+	"":                      true,
+	"mysql_native_password": true,
+	"mysql_old_password":    true,
+	"ed25519":               true,
+	"parsec":                true,
+	"sha256_password":       true,
+	"caching_sha2_password": true,
+}
+
+func localHost(h string) bool {
+	switch h {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+func account(u dbhelper.UserAccount) string {
+	return "'" + u.User + "'@'" + u.Host + "'"
+}
+
+// Returns true when the account has every global privilege.
+func allPrivileges(u dbhelper.UserAccount) bool {
+	if len(u.Privileges) == 0 {
+		return false
+	}
+	for _, p := range u.Privileges {
+		if !p {
+			return false
+		}
+	}
+	return true
+}
+
+// Adds the security audit section: accounts, test database and the server
+// settings exposing data or files.
+func securitySection(db *sqlx.DB, s *Section, top int) {
+	users, err := dbhelper.GetUsers(db)
+	if err != nil {
+		s.add("Accounts", "Unavailable: "+err.Error(), unitNone)
+	} else {
+		auditAccounts(s, users, top)
+	}
+	var schemas []string
+	db.Select(&schemas, "SELECT schema_name FROM information_schema.schemata WHERE schema_name = 'test' OR schema_name LIKE 'test\\_%'")
+	s.addNote("Test databases", len(schemas), unitNone, nameList(schemas, top))
+	var grants int64
+	if err = db.Get(&grants, "SELECT COUNT(*) FROM mysql.db WHERE Db LIKE 'test%' AND (User = '' OR Host = '%')"); err == nil && grants > 0 {
+		s.addNote("Test database grants", grants, unitNone, "anonymous or any-host accounts can use test databases")
+	}
+	var plugins []string
+	db.Select(&plugins, "SELECT plugin_name FROM information_schema.plugins WHERE plugin_type = 'AUTHENTICATION' AND plugin_status = 'ACTIVE' ORDER BY plugin_name")
+	s.add("Auth plugins loaded", strings.Join(plugins, ", "), unitNone)
+	tlsStatus(db, s)
+	local := variable["LOCAL_INFILE"]
+	if local == "ON" {
+		s.addNote("Local infile", local, unitNone, "clients can make the server read local files with LOAD DATA LOCAL")
+	} else {
+		s.add("Local infile", local, unitNone)
+	}
+	var sfp sql.NullString
+	db.Get(&sfp, "SELECT @@secure_file_priv")
+	switch {
+	case !sfp.Valid:
+		s.addNote("Secure file priv", "NULL", unitNone, "file import and export disabled")
+	case sfp.String == "":
+		s.addNote("Secure file priv", "empty", unitNone, "LOAD DATA and SELECT INTO OUTFILE can access any file readable by the server")
+	default:
+		s.add("Secure file priv", sfp.String, unitNone)
+	}
+}
+
+func auditAccounts(s *Section, users []dbhelper.UserAccount, top int) {
+	var noPassword, anonymous, wildcard, remoteRoot []string
+	plugins := make(map[string]int)
+	var accounts int
+	for _, u := range users {
+		// Roles cannot log in
+		if u.Is_role {
+			continue
+		}
+		accounts++
+		plugin := u.Plugin
+		if plugin == "" {
+			plugin = "mysql_native_password"
+		}
+		plugins[plugin]++
+		if passwordPlugins[u.Plugin] && u.Password == "" && u.Authentication_string == "" {
+			noPassword = append(noPassword, account(u))
+		}
+		if u.User == "" {
+			anonymous = append(anonymous, account(u))
+		}
+		if strings.ContainsAny(u.Host, "%_") && (u.Privileges["Super_priv"] || allPrivileges(u)) {
+			wildcard = append(wildcard, account(u))
+		}
+		if u.User == "root" && !localHost(u.Host) {
+			remoteRoot = append(remoteRoot, account(u))
+		}
+	}
+	s.add("Accounts", accounts, unitNone)
+	s.addNote("Without password", len(noPassword), unitNone, nameList(noPassword, top))
+	s.addNote("Anonymous accounts", len(anonymous), unitNone, nameList(anonymous, top))
+	s.addNote("Wildcard host admins", len(wildcard), unitNone, nameList(wildcard, top))
+	s.addNote("Remote root", len(remoteRoot), unitNone, nameList(remoteRoot, top))
+	names := make([]string, 0, len(plugins))
+	for p := range plugins {
+		names = append(names, p)
+	}
+	sort.Strings(names)
+	for _, p := range names {
+		s.add("Plugin "+p, plugins[p], unitNone)
+	}
+}
+
+func tlsStatus(db *sqlx.DB, s *Section) {
+	have := variable["HAVE_SSL"]
+	if have == "" {
+		have = variable["HAVE_OPENSSL"]
+	}
+	s.add("TLS", have, unitNone)
+	if have != "YES" {
+		return
+	}
+	if v, ok := variable["TLS_VERSION"]; ok {
+		s.add("TLS versions", v, unitNone)
+	}
+	if v, ok := variable["REQUIRE_SECURE_TRANSPORT"]; ok {
+		s.add("Require secure transport", v, unitNone)
+	}
+	// Tells whether the report itself is connected over TLS
+	var name, cipher string
+	if err := db.QueryRow("SHOW SESSION STATUS LIKE 'Ssl_cipher'").Scan(&name, &cipher); err == nil {
+		if cipher == "" {
+			cipher = "none"
+		}
+		s.add("Report connection cipher", cipher, unitNone)
+	}
+	if n := status["SSL_ACCEPTS"]; n > 0 {
+		s.addNote("TLS accepts", n, unitNone, fmt.Sprintf("%d sessions reused", status["SSL_SESSION_CACHE_HITS"]))
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func TestAuditAccountsPasswords(t *testing.T) {
+	tests := []struct {
+		name    string
+		account dbhelper.UserAccount
+		want    int
+	}{
+		{"old row without plugin", dbhelper.UserAccount{User: "a", Host: "h"}, 1},
+		{"native", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "mysql_native_password"}, 1},
+		{"native with password", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "mysql_native_password", Authentication_string: "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9"}, 0},
+		{"pre 10.4 password column", dbhelper.UserAccount{User: "a", Host: "h", Password: "*6BB4837EB74329105EE4568DDA7DC67ED2CA2AD9"}, 0},
+		{"ed25519", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "ed25519"}, 1},
+		{"ed25519 with password", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "ed25519", Authentication_string: "ZIgUREUg5PVgQ6LskhXmO+eZLS0nC8be6HPjYWR4YJY"}, 0},
+		{"caching_sha2_password", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "caching_sha2_password"}, 1},
+		{"unix_socket", dbhelper.UserAccount{User: "a", Host: "localhost", Plugin: "unix_socket"}, 0},
+		{"pam service", dbhelper.UserAccount{User: "a", Host: "h", Plugin: "pam"}, 0},
+		{"role", dbhelper.UserAccount{User: "r", Is_role: true}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Section{}
+			auditAccounts(s, []dbhelper.UserAccount{tt.account}, 10)
+			for _, it := range s.Items {
+				if it.Name == "Without password" {
+					if it.Value != tt.want {
+						t.Errorf("Without password = %v, want %d", it.Value, tt.want)
+					}
+					return
+				}
+			}
+			t.Error("no Without password item")
+		})
+	}
+}
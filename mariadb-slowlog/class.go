@@ -0,0 +1,262 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/tanji/mariadb-tools/digest"
+)
+
+// Longest example statement and fingerprint kept per class.
+const maxExample = 16 * 1024
+
+// Returns a copy of s cut to maxExample bytes, so that the whole statement it
+// was taken from is not kept in memory.
+func truncated(s string) string {
+	if len(s) > maxExample {
+		return s[:maxExample] + " /* truncated */"
+	}
+	return s
+}
+
+// Histogram buckets grow by 5%, percentiles are accurate to that.
+const (
+	histBase = 1.05
+	histMin  = 1e-6
+)
+
+// metric aggregates one numeric attribute. Its histogram only has a bucket
+// per order of magnitude seen, so its size does not depend on the number of
+// entries.
+type metric struct {
+	count int64
+	total float64
+	min   float64
+	max   float64
+	hist  map[int]int64
+}
+
+func bucket(v float64) int {
+	if v <= 0 {
+		return -1
+	}
+	if v <= histMin {
+		return 0
+	}
+	return int(math.Log(v/histMin)/math.Log(histBase)) + 1
+}
+
+// Upper bound of a bucket.
+func bucketValue(b int) float64 {
+	if b < 0 {
+		return 0
+	}
+	return histMin * math.Pow(histBase, float64(b))
+}
+
+func (m *metric) add(v float64) {
+	if m.count == 0 || v < m.min {
+		m.min = v
+	}
+	if m.count == 0 || v > m.max {
+		m.max = v
+	}
+	m.count++
+	m.total += v
+	m.hist[bucket(v)]++
+}
+
+func (m *metric) avg() float64 {
+	if m.count == 0 {
+		return 0
+	}
+	return m.total / float64(m.count)
+}
+
+func (m *metric) percentile(p float64) float64 {
+	buckets := make([]int, 0, len(m.hist))
+	for b := range m.hist {
+		buckets = append(buckets, b)
+	}
+	sort.Ints(buckets)
+	rank := int64(math.Ceil(p * float64(m.count)))
+	var seen int64
+	for _, b := range buckets {
+		seen += m.hist[b]
+		if seen >= rank {
+			return math.Min(bucketValue(b), m.max)
+		}
+	}
+	return m.max
+}
+
+// Most distinct values counted per class. Past that, entries with a new value
+// are only counted as others, so that many clients or databases do not grow
+// the memory of every class.
+const maxValues = 100
+
+// valueCounts counts the entries by value, such as their database.
+type valueCounts struct {
+	counts map[string]int64
+	others int64
+}
+
+func newValueCounts() *valueCounts {
+	return &valueCounts{counts: make(map[string]int64)}
+}
+
+func (v *valueCounts) add(value string) {
+	if _, ok := v.counts[value]; ok || len(v.counts) < maxValues {
+		v.counts[value]++
+		return
+	}
+	v.others++
+}
+
+// Query time distribution, by order of magnitude from 1us to 10s and more.
+var distribution = []string{"1us", "10us", "100us", "1ms", "10ms", "100ms", "1s", "10s+"}
+
+// class aggregates the entries sharing a fingerprint. Only aggregates and the
+// slowest example are kept.
+type class struct {
+	checksum     string
+	fingerprint  string
+	count        int64
+	first        time.Time
+	last         time.Time
+	metrics      map[string]*metric
+	flags        map[string]int64
+	databases    *valueCounts
+	users        *valueCounts
+	distribution [8]int64
+	example      string
+	exampleTime  float64
+	exampleDB    string
+	explain      []string
+}
+
+func newClass(fingerprint string) *class {
+	return &class{
+		checksum:    digest.Checksum(fingerprint),
+		fingerprint: truncated(fingerprint),
+		metrics:     make(map[string]*metric),
+		flags:       make(map[string]int64),
+		databases:   newValueCounts(),
+		users:       newValueCounts(),
+	}
+}
+
+func (c *class) add(e *entry) {
+	c.count++
+	if !e.time.IsZero() {
+		if c.first.IsZero() || e.time.Before(c.first) {
+			c.first = e.time
+		}
+		if e.time.After(c.last) {
+			c.last = e.time
+		}
+	}
+	for name, v := range e.metrics {
+		m, ok := c.metrics[name]
+		if !ok {
+			m = &metric{hist: make(map[int]int64)}
+			c.metrics[name] = m
+		}
+		m.add(v)
+	}
+	for name, yes := range e.flags {
+		if yes {
+			c.flags[name]++
+		}
+	}
+	if e.database != "" {
+		c.databases.add(e.database)
+	}
+	if e.user != "" {
+		c.users.add(e.user + "@" + e.host)
+	}
+	qt := e.metrics["Query_time"]
+	d := 0
+	if qt > histMin {
+		d = int(math.Floor(math.Log10(qt / histMin)))
+	}
+	c.distribution[max(0, min(d, len(c.distribution)-1))]++
+	if c.example == "" || qt > c.exampleTime {
+		c.example = truncated(e.query)
+		c.exampleTime = qt
+		c.exampleDB = e.database
+		c.explain = e.explain
+	}
+}
+
+// Seconds between the first and the last entry.
+func (c *class) span() float64 {
+	if c.first.IsZero() {
+		return 0
+	}
+	return c.last.Sub(c.first).Seconds()
+}
+
+// aggregator groups the entries by fingerprint, overall holding the totals of
+// all the entries. The classes are keyed by the checksum of their fingerprint,
+// which can be as long as the statement. Once maxClasses fingerprints are
+// tracked, the entries of new fingerprints are aggregated together in
+// untracked.
+type aggregator struct {
+	classes    map[string]*class
+	overall    *class
+	untracked  *class
+	maxClasses int
+}
+
+func newAggregator(maxClasses int) *aggregator {
+	return &aggregator{classes: make(map[string]*class), overall: newClass(""), maxClasses: maxClasses}
+}
+
+func (a *aggregator) add(e *entry) {
+	fp := digest.Fingerprint(e.query)
+	c, ok := a.classes[digest.Checksum(fp)]
+	switch {
+	case ok:
+	case a.maxClasses > 0 && len(a.classes) >= a.maxClasses:
+		if a.untracked == nil {
+			a.untracked = newClass("")
+		}
+		c = a.untracked
+	default:
+		c = newClass(fp)
+		a.classes[c.checksum] = c
+	}
+	c.add(e)
+	a.overall.add(e)
+}
+
+// Returns the classes by decreasing total of an attribute, or by decreasing
+// number of calls when orderBy is count.
+func (a *aggregator) ranking(orderBy string) []*class {
+	ranking := make([]*class, 0, len(a.classes))
+	for _, c := range a.classes {
+		ranking = append(ranking, c)
+	}
+	key := func(c *class) float64 {
+		if orderBy == "count" {
+			return float64(c.count)
+		}
+		if m, ok := c.metrics[orderBy]; ok {
+			return m.total
+		}
+		return 0
+	}
+	sort.Slice(ranking, func(i, j int) bool {
+		ki, kj := key(ranking[i]), key(ranking[j])
+		if ki != kj {
+			return ki > kj
+		}
+		if ranking[i].count != ranking[j].count {
+			return ranking[i].count > ranking[j].count
+		}
+		return ranking[i].checksum < ranking[j].checksum
+	})
+	return ranking
+}
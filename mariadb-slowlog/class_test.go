@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newEntry(query string, qt float64, db, user string) *entry {
+	return &entry{
+		time:     time.Unix(1697619901, 0),
+		user:     user,
+		host:     "h",
+		database: db,
+		query:    query,
+		metrics:  map[string]float64{"Query_time": qt, "Rows_examined": qt * 1000},
+		flags:    map[string]bool{"Full_scan": qt > 1},
+	}
+}
+
+func TestAggregator(t *testing.T) {
+	a := newAggregator(0)
+	a.add(newEntry("SELECT * FROM t WHERE id = 1", 0.5, "shop", "app"))
+	a.add(newEntry("select * from t where id = 2", 2, "shop", "app"))
+	a.add(newEntry("SELECT * FROM t WHERE id=3", 1, "test", "web"))
+	a.add(newEntry("INSERT INTO t VALUES (1), (2)", 0.01, "shop", "app"))
+
+	if len(a.classes) != 3 {
+		t.Fatalf("%d classes, want 3", len(a.classes))
+	}
+	ranking := a.ranking("Query_time")
+	c := ranking[0]
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"fingerprint", c.fingerprint, "select * from t where id = ?"},
+		{"count", c.count, int64(2)},
+		{"total", c.metrics["Query_time"].total, 2.5},
+		{"min", c.metrics["Query_time"].min, 0.5},
+		{"max", c.metrics["Query_time"].max, 2.0},
+		{"full scans", c.flags["Full_scan"], int64(1)},
+		{"databases", c.databases.counts["shop"], int64(2)},
+		{"example", c.example, "select * from t where id = 2"},
+		{"example time", c.exampleTime, 2.0},
+		{"100ms distribution", c.distribution[5], int64(1)},
+		{"1s distribution", c.distribution[6], int64(1)},
+		{"overall count", a.overall.count, int64(4)},
+		{"ranked by count", a.ranking("count")[0].count, int64(2)},
+		{"ranked by rows", a.ranking("Rows_examined")[0].fingerprint, "select * from t where id = ?"},
+		{"ranked last", ranking[2].fingerprint, "insert into t values (?+)"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{"single", []float64{0.25}, 0.95, 0.25},
+		{"uniform", func() []float64 {
+			var v []float64
+			for i := 1; i <= 100; i++ {
+				v = append(v, float64(i))
+			}
+			return v
+		}(), 0.95, 95},
+		{"zeros", []float64{0, 0, 0}, 0.95, 0},
+		{"outlier above 95%", append(make([]float64, 99), 1000), 0.95, 0},
+		{"microseconds", []float64{0.000001, 0.000002, 0.000003}, 0.5, 0.000002},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &metric{hist: make(map[int]int64)}
+			for _, v := range tt.values {
+				m.add(v)
+			}
+			// Buckets are 5% wide
+			if got := m.percentile(tt.p); math.Abs(got-tt.want) > tt.want*histBase-tt.want+1e-12 {
+				t.Errorf("percentile(%v) = %v, want %v within 5%%", tt.p, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValueCountsCap(t *testing.T) {
+	v := newValueCounts()
+	for i := 0; i < maxValues+10; i++ {
+		v.add(fmt.Sprintf("user%d", i))
+	}
+	v.add("user0")
+	if len(v.counts) != maxValues || v.others != 10 || v.counts["user0"] != 2 {
+		t.Errorf("%d values, %d others, user0 counted %d, want %d, 10 and 2", len(v.counts), v.others, v.counts["user0"], maxValues)
+	}
+}
+
+func TestMaxClasses(t *testing.T) {
+	a := newAggregator(2)
+	for i := 0; i < 5; i++ {
+		a.add(newEntry(fmt.Sprintf("SELECT * FROM t%d", i), 1, "", "app"))
+	}
+	a.add(newEntry("SELECT * FROM t0", 1, "", "app"))
+	if len(a.classes) != 2 || a.untracked == nil || a.untracked.count != 3 || a.overall.count != 6 {
+		t.Fatalf("%d classes, untracked %+v, overall %d", len(a.classes), a.untracked, a.overall.count)
+	}
+	r := buildReport(a, []string{"-"}, "Query_time", 1)
+	if r.Others == nil || r.Others.Classes != 1 || r.Others.Untracked != 3 || r.Others.Count != 4 {
+		t.Errorf("others = %+v, want 1 class, 3 untracked and 4 statements", r.Others)
+	}
+}
+
+func TestLongFingerprint(t *testing.T) {
+	a := newAggregator(0)
+	long := "SELECT " + strings.Repeat("col, ", maxExample)
+	a.add(newEntry(long+"a FROM t", 1, "", "app"))
+	a.add(newEntry(long+"b FROM t", 1, "", "app"))
+	a.add(newEntry(long+"b FROM t", 2, "", "app"))
+	if len(a.classes) != 2 {
+		t.Fatalf("%d classes, want 2", len(a.classes))
+	}
+	for _, c := range a.classes {
+		if len(c.fingerprint) > maxExample+len(" /* truncated */") || len(c.example) > maxExample+len(" /* truncated */") {
+			t.Errorf("fingerprint of %d bytes and example of %d bytes kept", len(c.fingerprint), len(c.example))
+		}
+	}
+}
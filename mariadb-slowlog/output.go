@@ -0,0 +1,396 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Attributes listed first, in this order, the others follow by name.
+var attributeOrder = []string{"Query_time", "Lock_time", "Rows_sent", "Rows_examined", "Rows_affected", "Bytes_sent",
+	"Tmp_tables", "Tmp_disk_tables", "Tmp_table_sizes", "Merge_passes"}
+
+type attribute struct {
+	Name  string  `json:"name"`
+	Pct   float64 `json:"pct,omitempty"`
+	Total float64 `json:"total"`
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Avg   float64 `json:"avg"`
+	P95   float64 `json:"p95"`
+}
+
+type rangeCount struct {
+	Range string `json:"range"`
+	Count int64  `json:"count"`
+}
+
+type overallSummary struct {
+	Count      int64            `json:"count"`
+	Unique     int              `json:"unique"`
+	QPS        float64          `json:"qps"`
+	First      time.Time        `json:"first_seen"`
+	Last       time.Time        `json:"last_seen"`
+	Attributes []attribute      `json:"attributes"`
+	Flags      map[string]int64 `json:"flags"`
+}
+
+type querySummary struct {
+	Rank          int              `json:"rank"`
+	Checksum      string           `json:"checksum"`
+	Fingerprint   string           `json:"fingerprint"`
+	Count         int64            `json:"count"`
+	ResponseTime  float64          `json:"response_time"`
+	ResponseShare float64          `json:"response_share"`
+	QPS           float64          `json:"qps"`
+	First         time.Time        `json:"first_seen"`
+	Last          time.Time        `json:"last_seen"`
+	Attributes    []attribute      `json:"attributes"`
+	Flags         map[string]int64 `json:"flags"`
+	Databases     map[string]int64 `json:"databases"`
+	OtherDBs      int64            `json:"other_databases,omitempty"`
+	Users         map[string]int64 `json:"users"`
+	OtherUsers    int64            `json:"other_users,omitempty"`
+	Distribution  []rangeCount     `json:"query_time_distribution"`
+	Example       string           `json:"example"`
+	ExampleTime   float64          `json:"example_query_time"`
+	ExampleDB     string           `json:"example_database,omitempty"`
+	Explain       []string         `json:"explain,omitempty"`
+}
+
+// Classes past the limit, reported as a single line. Untracked counts the
+// statements of the fingerprints past -max-classes.
+type otherSummary struct {
+	Classes      int     `json:"classes"`
+	Count        int64   `json:"count"`
+	Untracked    int64   `json:"untracked,omitempty"`
+	ResponseTime float64 `json:"response_time"`
+}
+
+type slowlogReport struct {
+	Files   []string       `json:"files"`
+	OrderBy string         `json:"order_by"`
+	Overall overallSummary `json:"overall"`
+	Queries []querySummary `json:"queries"`
+	Others  *otherSummary  `json:"others,omitempty"`
+}
+
+func render(w io.Writer, a *aggregator, files []string, format, orderBy string, limit int) error {
+	r := buildReport(a, files, orderBy, limit)
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	renderText(w, r)
+	return nil
+}
+
+func buildReport(a *aggregator, files []string, orderBy string, limit int) *slowlogReport {
+	o := a.overall
+	r := &slowlogReport{
+		Files:   files,
+		OrderBy: orderBy,
+		Overall: overallSummary{Count: o.count, Unique: len(a.classes), QPS: qps(o), First: o.first, Last: o.last,
+			Attributes: attributes(o, nil), Flags: o.flags},
+		Queries: []querySummary{},
+	}
+	for i, c := range a.ranking(orderBy) {
+		if limit > 0 && i >= limit {
+			if r.Others == nil {
+				r.Others = &otherSummary{}
+			}
+			r.Others.Classes++
+			r.Others.Count += c.count
+			r.Others.ResponseTime += total(c, "Query_time")
+			continue
+		}
+		q := querySummary{
+			Rank:          i + 1,
+			Checksum:      "0x" + c.checksum,
+			Fingerprint:   c.fingerprint,
+			Count:         c.count,
+			ResponseTime:  total(c, "Query_time"),
+			ResponseShare: share(total(c, "Query_time"), total(o, "Query_time")),
+			QPS:           qps(c),
+			First:         c.first,
+			Last:          c.last,
+			Attributes:    attributes(c, o),
+			Flags:         c.flags,
+			Databases:     c.databases.counts,
+			OtherDBs:      c.databases.others,
+			Users:         c.users.counts,
+			OtherUsers:    c.users.others,
+			Example:       c.example,
+			ExampleTime:   c.exampleTime,
+			ExampleDB:     c.exampleDB,
+			Explain:       c.explain,
+		}
+		for d, n := range c.distribution {
+			q.Distribution = append(q.Distribution, rangeCount{distribution[d], n})
+		}
+		r.Queries = append(r.Queries, q)
+	}
+	if u := a.untracked; u != nil {
+		if r.Others == nil {
+			r.Others = &otherSummary{}
+		}
+		r.Others.Count += u.count
+		r.Others.Untracked = u.count
+		r.Others.ResponseTime += total(u, "Query_time")
+	}
+	return r
+}
+
+func total(c *class, name string) float64 {
+	if m, ok := c.metrics[name]; ok {
+		return m.total
+	}
+	return 0
+}
+
+func share(v, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return math.Round(v/total*1000) / 10
+}
+
+func qps(c *class) float64 {
+	if c.span() == 0 {
+		return 0
+	}
+	return math.Round(float64(c.count)/c.span()*100) / 100
+}
+
+// Returns the attributes of a class, with their share of the overall totals
+// when overall is given.
+func attributes(c, overall *class) []attribute {
+	names := make([]string, 0, len(c.metrics))
+	for name := range c.metrics {
+		names = append(names, name)
+	}
+	position := func(name string) int {
+		for i, n := range attributeOrder {
+			if n == name {
+				return i
+			}
+		}
+		return len(attributeOrder)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		pi, pj := position(names[i]), position(names[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return names[i] < names[j]
+	})
+	var attrs []attribute
+	for _, name := range names {
+		m := c.metrics[name]
+		a := attribute{Name: name, Total: m.total, Min: m.min, Max: m.max, Avg: m.avg(), P95: m.percentile(0.95)}
+		if overall != nil {
+			a.Pct = share(m.total, total(overall, name))
+		}
+		attrs = append(attrs, a)
+	}
+	return attrs
+}
+
+func renderText(w io.Writer, r *slowlogReport) {
+	o := r.Overall
+	fmt.Fprintf(w, "# Overall: %s total, %d unique, %s QPS%s\n", formatCount(float64(o.Count)), o.Unique, formatCount(o.QPS), timeRange(o.First, o.Last))
+	fmt.Fprintf(w, "# Files: %s\n#\n", strings.Join(r.Files, ", "))
+	fmt.Fprintf(w, "# %-16s %9s %9s %9s %9s %9s\n", "Attribute", "total", "min", "max", "avg", "95%")
+	fmt.Fprintf(w, "# %-16s %9s %9s %9s %9s %9s\n", strings.Repeat("=", 16), "=========", "=========", "=========", "=========", "=========")
+	for _, a := range o.Attributes {
+		fmt.Fprintf(w, "# %-16s %9s %9s %9s %9s %9s\n", a.Name, formatAttribute(a.Name, a.Total), formatAttribute(a.Name, a.Min),
+			formatAttribute(a.Name, a.Max), formatAttribute(a.Name, a.Avg), formatAttribute(a.Name, a.P95))
+	}
+	if len(o.Flags) > 0 {
+		fmt.Fprintf(w, "# %-16s %s\n", "Flags", flagList(o.Flags, o.Count))
+	}
+	if o.Count == 0 {
+		fmt.Fprintln(w, "\n# No statement found")
+		return
+	}
+
+	fmt.Fprintf(w, "\n# Profile, ranked by %s\n", r.OrderBy)
+	fmt.Fprintf(w, "# %4s %-18s %15s %7s %9s  %s\n", "Rank", "Query ID", "Response time", "Calls", "R/Call", "Fingerprint")
+	fmt.Fprintf(w, "# %4s %-18s %15s %7s %9s  %s\n", "====", strings.Repeat("=", 18), strings.Repeat("=", 15), "=======", "=========", "===========")
+	for _, q := range r.Queries {
+		fmt.Fprintf(w, "# %4d %-18s %8.4f %5.1f%% %7d %9.4f  %s\n", q.Rank, q.Checksum, q.ResponseTime, q.ResponseShare, q.Count,
+			q.ResponseTime/float64(q.Count), oneLine(q.Fingerprint, 60))
+	}
+	if r.Others != nil {
+		item := fmt.Sprintf("<%d classes>", r.Others.Classes)
+		if r.Others.Untracked > 0 {
+			item = fmt.Sprintf("<%d classes and %d statements past -max-classes>", r.Others.Classes, r.Others.Untracked)
+		}
+		fmt.Fprintf(w, "# %4s %-18s %8.4f %5.1f%% %7d %9.4f  %s\n", "MISC", "0xMISC", r.Others.ResponseTime,
+			share(r.Others.ResponseTime, overallTotal(r, "Query_time")), r.Others.Count, r.Others.ResponseTime/float64(r.Others.Count), item)
+	}
+
+	for _, q := range r.Queries {
+		fmt.Fprintf(w, "\n# Query %d: %s QPS, ID %s%s\n", q.Rank, formatCount(q.QPS), q.Checksum, timeRange(q.First, q.Last))
+		fmt.Fprintf(w, "# %-16s %5s %9s %9s %9s %9s %9s\n", "Attribute", "pct", "total", "min", "max", "avg", "95%")
+		fmt.Fprintf(w, "# %-16s %5s %9s %9s %9s %9s %9s\n", strings.Repeat("=", 16), "=====", "=========", "=========", "=========", "=========", "=========")
+		fmt.Fprintf(w, "# %-16s %5.0f %9d\n", "Count", share(float64(q.Count), float64(o.Count)), q.Count)
+		for _, a := range q.Attributes {
+			fmt.Fprintf(w, "# %-16s %5.0f %9s %9s %9s %9s %9s\n", a.Name, a.Pct, formatAttribute(a.Name, a.Total), formatAttribute(a.Name, a.Min),
+				formatAttribute(a.Name, a.Max), formatAttribute(a.Name, a.Avg), formatAttribute(a.Name, a.P95))
+		}
+		if len(q.Databases) > 0 {
+			fmt.Fprintf(w, "# %-16s %s\n", "Databases", countList(q.Databases, q.OtherDBs, q.Count))
+		}
+		if len(q.Users) > 0 {
+			fmt.Fprintf(w, "# %-16s %s\n", "Users", countList(q.Users, q.OtherUsers, q.Count))
+		}
+		if len(q.Flags) > 0 {
+			fmt.Fprintf(w, "# %-16s %s\n", "Flags", flagList(q.Flags, q.Count))
+		}
+		fmt.Fprintln(w, "# Query_time distribution")
+		var peak int64
+		for _, d := range q.Distribution {
+			peak = max(peak, d.Count)
+		}
+		for _, d := range q.Distribution {
+			bar := 0
+			if peak > 0 {
+				bar = int(math.Ceil(float64(d.Count) / float64(peak) * 50))
+			}
+			fmt.Fprintf(w, "# %6s  %s\n", d.Range, strings.Repeat("#", bar))
+		}
+		if len(q.Explain) > 0 {
+			fmt.Fprintln(w, "# EXPLAIN of the slowest example")
+			for _, line := range q.Explain {
+				fmt.Fprintf(w, "# %s\n", line)
+			}
+		}
+		fmt.Fprintf(w, "# Slowest example, %s\n", formatTime(q.ExampleTime))
+		if q.ExampleDB != "" {
+			fmt.Fprintf(w, "USE `%s`;\n", q.ExampleDB)
+		}
+		fmt.Fprintf(w, "%s;\n", q.Example)
+	}
+}
+
+func overallTotal(r *slowlogReport, name string) float64 {
+	for _, a := range r.Overall.Attributes {
+		if a.Name == name {
+			return a.Total
+		}
+	}
+	return 0
+}
+
+func timeRange(first, last time.Time) string {
+	if first.IsZero() {
+		return ""
+	}
+	const layout = "2006-01-02 15:04:05"
+	return fmt.Sprintf(", from %s to %s", first.Format(layout), last.Format(layout))
+}
+
+// Lists the values by decreasing count, with their share of n. others is the
+// number of entries whose value was not counted.
+func countList(counts map[string]int64, others int64, n int64) string {
+	names := make([]string, 0, len(counts))
+	for name := range counts {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if counts[names[i]] != counts[names[j]] {
+			return counts[names[i]] > counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	var list []string
+	for i, name := range names {
+		if i == 5 {
+			list = append(list, fmt.Sprintf("%d more", len(names)-i))
+			break
+		}
+		list = append(list, fmt.Sprintf("%s (%.0f%%)", name, share(float64(counts[name]), float64(n))))
+	}
+	if others > 0 {
+		list = append(list, fmt.Sprintf("others (%.0f%%)", share(float64(others), float64(n))))
+	}
+	return strings.Join(list, ", ")
+}
+
+func flagList(flags map[string]int64, n int64) string {
+	names := make([]string, 0, len(flags))
+	for name := range flags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var list []string
+	for _, name := range names {
+		list = append(list, fmt.Sprintf("%s %.0f%%", name, share(float64(flags[name]), float64(n))))
+	}
+	return strings.Join(list, ", ")
+}
+
+func formatAttribute(name string, v float64) string {
+	switch {
+	case strings.HasSuffix(name, "_time"):
+		return formatTime(v)
+	case name == "Bytes_sent" || name == "Tmp_table_sizes":
+		return humanize.IBytes(uint64(v))
+	}
+	return formatCount(v)
+}
+
+func formatTime(s float64) string {
+	switch {
+	case s == 0:
+		return "0"
+	case s >= 1:
+		return shortFloat(s) + "s"
+	case s >= 1e-3:
+		return shortFloat(s*1e3) + "ms"
+	}
+	return shortFloat(s*1e6) + "us"
+}
+
+func formatCount(v float64) string {
+	switch {
+	case v >= 1e9:
+		return shortFloat(v/1e9) + "G"
+	case v >= 1e6:
+		return shortFloat(v/1e6) + "M"
+	case v >= 1e3:
+		return shortFloat(v/1e3) + "k"
+	case v == math.Trunc(v):
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return shortFloat(v)
+}
+
+// Keeps about three significant digits.
+func shortFloat(v float64) string {
+	switch {
+	case v >= 100:
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	case v >= 10:
+		return strconv.FormatFloat(v, 'f', 1, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// Squeezes whitespace and shortens a statement to max characters.
+func oneLine(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if r := []rune(s); len(r) > max {
+		return string(r[:max-3]) + "..."
+	}
+	return s
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Longest statement kept in memory, the rest of the line is discarded. This
+// bounds the memory used by multi-megabyte INSERT statements.
+const maxQuery = 1 << 20
+
+// entry is one statement of the slow query log with its header attributes.
+type entry struct {
+	time     time.Time
+	user     string
+	host     string
+	database string
+	query    string
+	metrics  map[string]float64
+	flags    map[string]bool
+	explain  []string
+}
+
+// logParser reads a slow query log one entry at a time, so that logs of any
+// size are streamed. The # Time: header and the use statement are only
+// written when they change, they are carried over to the next entries.
+type logParser struct {
+	r        *bufio.Reader
+	time     time.Time
+	database string
+	pending  string
+}
+
+func newLogParser(r io.Reader) *logParser {
+	return &logParser{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// Reads a line without its end of line, truncated to maxQuery bytes.
+func (p *logParser) readLine() (string, error) {
+	if p.pending != "" {
+		line := p.pending
+		p.pending = ""
+		return line, nil
+	}
+	var b []byte
+	for {
+		chunk, err := p.r.ReadSlice('\n')
+		b = append(b, chunk[:min(len(chunk), maxQuery-len(b))]...)
+		if err != bufio.ErrBufferFull {
+			if err == io.EOF && len(b) > 0 {
+				err = nil
+			}
+			return strings.TrimRight(string(b), "\r\n"), err
+		}
+	}
+}
+
+// Returns the next entry, or io.EOF at the end of the log.
+func (p *logParser) next() (*entry, error) {
+	var e *entry
+	var query strings.Builder
+	for {
+		line, err := p.readLine()
+		if err != nil {
+			if err == io.EOF && query.Len() > 0 {
+				return p.finish(e, &query), nil
+			}
+			return nil, err
+		}
+		// A new header or a server restart ends the current statement.
+		if query.Len() > 0 && (strings.HasPrefix(line, "# Time:") || strings.HasPrefix(line, "# User@Host:") || strings.HasSuffix(line, "started with:")) {
+			p.pending = line
+			return p.finish(e, &query), nil
+		}
+		switch {
+		case query.Len() > 0:
+			if query.Len()+len(line) < maxQuery {
+				query.WriteByte('\n')
+				query.WriteString(line)
+			}
+		case strings.HasPrefix(line, "# administrator command:"):
+			if e != nil {
+				query.WriteString(strings.TrimPrefix(line, "# "))
+			}
+		case strings.HasPrefix(line, "#"):
+			if e == nil {
+				e = &entry{metrics: make(map[string]float64), flags: make(map[string]bool)}
+			}
+			p.header(e, line)
+		case e == nil:
+			// Server startup banner: version, ports and column titles.
+		case strings.HasPrefix(strings.ToLower(line), "use "):
+			p.database = strings.Trim(strings.TrimSpace(line[4:]), "`;")
+		case strings.HasPrefix(strings.ToLower(line), "set timestamp="):
+			ts := strings.TrimSuffix(line[len("set timestamp="):], ";")
+			if sec, err := strconv.ParseFloat(ts, 64); err == nil {
+				e.time = time.Unix(int64(sec), int64((sec-float64(int64(sec)))*1e9))
+			}
+		case strings.TrimSpace(line) != "":
+			query.WriteString(line)
+		}
+	}
+}
+
+func (p *logParser) finish(e *entry, query *strings.Builder) *entry {
+	if e == nil {
+		e = &entry{metrics: make(map[string]float64), flags: make(map[string]bool)}
+	}
+	if e.time.IsZero() {
+		e.time = p.time
+	}
+	if e.database == "" {
+		e.database = p.database
+	}
+	e.query = strings.TrimSuffix(strings.TrimSpace(query.String()), ";")
+	return e
+}
+
+// Parses a header line. Most are lists of Name: value pairs, numbers are
+// metrics and Yes/No values are flags:
+//
+//	# Thread_id: 8  Schema: shop  QC_hit: No
+//	# Query_time: 2.104  Lock_time: 0.000051  Rows_sent: 10  Rows_examined: 482313
+//	# Full_scan: Yes  Full_join: No  Tmp_table: Yes  Tmp_table_on_disk: No
+func (p *logParser) header(e *entry, line string) {
+	line = strings.TrimSpace(strings.TrimPrefix(line, "#"))
+	switch {
+	case strings.HasPrefix(line, "Time:"):
+		if t, ok := parseLogTime(strings.TrimPrefix(line, "Time:")); ok {
+			p.time = t
+		}
+		return
+	case strings.HasPrefix(line, "User@Host:"):
+		e.user, e.host = parseUserHost(strings.TrimPrefix(line, "User@Host:"))
+		return
+	case strings.HasPrefix(line, "explain:"):
+		e.explain = append(e.explain, strings.TrimPrefix(strings.TrimPrefix(line, "explain:"), " "))
+		return
+	}
+	fields := strings.Fields(line)
+	for i, f := range fields {
+		if !strings.HasSuffix(f, ":") || len(f) == 1 {
+			continue
+		}
+		name := strings.TrimSuffix(f, ":")
+		value := ""
+		if i+1 < len(fields) && !strings.HasSuffix(fields[i+1], ":") {
+			value = fields[i+1]
+		}
+		switch {
+		case name == "Schema":
+			// An empty schema means no default database.
+			e.database = value
+			p.database = value
+		case name == "Thread_id" || name == "Id":
+		case value == "Yes" || value == "No":
+			e.flags[name] = value == "Yes"
+		default:
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				e.metrics[name] = v
+			}
+		}
+	}
+}
+
+// Header timestamps are written by MariaDB as 231018 9:05:01, and in ISO
+// 8601 by MySQL 5.7 and later.
+func parseLogTime(s string) (time.Time, bool) {
+	s = strings.Join(strings.Fields(s), " ")
+	for _, layout := range []string{"060102 15:04:05", time.RFC3339Nano, "2006-01-02T15:04:05.999999"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Parses user[user] @ host [ip], the host being empty for connections not
+// resolved by name.
+func parseUserHost(s string) (string, string) {
+	if i := strings.Index(s, " Id:"); i >= 0 {
+		s = s[:i]
+	}
+	at := strings.Index(s, "@")
+	if at < 0 {
+		return strings.TrimSpace(s), ""
+	}
+	user := strings.TrimSpace(s[:at])
+	if i := strings.IndexByte(user, '['); i >= 0 {
+		user = user[:i]
+	}
+	host := strings.TrimSpace(s[at+1:])
+	if i := strings.IndexByte(host, '['); i >= 0 {
+		ip := strings.Trim(host[i:], "[] ")
+		host = strings.TrimSpace(host[:i])
+		if host == "" {
+			host = ip
+		}
+	}
+	return user, host
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const mariadbLog = `/usr/sbin/mariadbd, Version: 10.6.12-MariaDB-log (MariaDB Server). started with:
+Tcp port: 3306  Unix socket: /run/mysqld/mysqld.sock
+Time		    Id Command	Argument
+# Time: 231018  9:05:01
+# User@Host: app[app] @ web1 [10.0.0.5]
+# Thread_id: 8  Schema: shop  QC_hit: No
+# Query_time: 2.104000  Lock_time: 0.000051  Rows_sent: 10  Rows_examined: 482313
+# Rows_affected: 0  Bytes_sent: 1024
+# Full_scan: Yes  Full_join: No  Tmp_table: Yes  Tmp_table_on_disk: No
+#
+# explain: id	select_type	table	type
+# explain: 1	SIMPLE	orders	ALL
+#
+use shop;
+SET timestamp=1697619901;
+SELECT customer_id FROM orders
+WHERE status = 'open';
+# User@Host: app[app] @  [10.0.0.6]
+# Thread_id: 9  Schema:   QC_hit: No
+# Query_time: 0.500000  Lock_time: 0.000010  Rows_sent: 1  Rows_examined: 1000
+SELECT 1;
+# Time: 231018 10:00:00
+# User@Host: root[root] @ localhost []
+# Query_time: 0.000200  Lock_time: 0.000000  Rows_sent: 0  Rows_examined: 0
+SET timestamp=1697623201;
+# administrator command: Quit;
+/usr/sbin/mariadbd, Version: 10.6.12-MariaDB-log (MariaDB Server). started with:
+Tcp port: 3306  Unix socket: /run/mysqld/mysqld.sock
+Time		    Id Command	Argument
+`
+
+const mysqlLog = `# Time: 2023-10-18T09:05:01.123456Z
+# User@Host: app[app] @ web1 [10.0.0.5]  Id:     8
+# Query_time: 1.500000  Lock_time: 0.000100 Rows_sent: 5  Rows_examined: 50
+use shop;
+SET timestamp=1697619901;
+SELECT * FROM t WHERE id = 5;
+`
+
+func parseAll(t *testing.T, log string) []*entry {
+	t.Helper()
+	p := newLogParser(strings.NewReader(log))
+	var entries []*entry
+	for {
+		e, err := p.next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, e)
+	}
+}
+
+func TestParser(t *testing.T) {
+	tests := []struct {
+		name string
+		log  string
+		want []entry
+	}{
+		{"mariadb", mariadbLog, []entry{
+			{
+				time: time.Unix(1697619901, 0), user: "app", host: "web1", database: "shop",
+				query:   "SELECT customer_id FROM orders\nWHERE status = 'open'",
+				metrics: map[string]float64{"Query_time": 2.104, "Lock_time": 0.000051, "Rows_sent": 10, "Rows_examined": 482313, "Rows_affected": 0, "Bytes_sent": 1024},
+				flags:   map[string]bool{"QC_hit": false, "Full_scan": true, "Full_join": false, "Tmp_table": true, "Tmp_table_on_disk": false},
+				explain: []string{"id\tselect_type\ttable\ttype", "1\tSIMPLE\torders\tALL"},
+			},
+			{
+				// No SET timestamp, the last # Time: header is used. An empty
+				// schema is no default database.
+				time: time.Date(2023, 10, 18, 9, 5, 1, 0, time.Local), user: "app", host: "10.0.0.6",
+				query:   "SELECT 1",
+				metrics: map[string]float64{"Query_time": 0.5, "Lock_time": 0.00001, "Rows_sent": 1, "Rows_examined": 1000},
+				flags:   map[string]bool{"QC_hit": false},
+			},
+			{
+				time: time.Unix(1697623201, 0), user: "root", host: "localhost",
+				query:   "administrator command: Quit",
+				metrics: map[string]float64{"Query_time": 0.0002, "Lock_time": 0, "Rows_sent": 0, "Rows_examined": 0},
+				flags:   map[string]bool{},
+			},
+		}},
+		{"mysql", mysqlLog, []entry{
+			{
+				time: time.Unix(1697619901, 0), user: "app", host: "web1", database: "shop",
+				query:   "SELECT * FROM t WHERE id = 5",
+				metrics: map[string]float64{"Query_time": 1.5, "Lock_time": 0.0001, "Rows_sent": 5, "Rows_examined": 50},
+				flags:   map[string]bool{},
+			},
+		}},
+		{"empty", "", nil},
+		{"banner only", "/usr/sbin/mariadbd, Version: 10.6.12. started with:\nTcp port: 0  Unix socket: (null)\nTime Id Command Argument\n", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseAll(t, tt.log)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d entries, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				g, w := got[i], tt.want[i]
+				if !g.time.Equal(w.time) {
+					t.Errorf("entry %d: time = %s, want %s", i, g.time, w.time)
+				}
+				g.time, w.time = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(*g, w) {
+					t.Errorf("entry %d:\ngot  %+v\nwant %+v", i, *g, w)
+				}
+			}
+		})
+	}
+}
+
+func TestParserLongLine(t *testing.T) {
+	long := "INSERT INTO t VALUES " + strings.Repeat("(1),", maxQuery)
+	log := "# User@Host: a[a] @ h []\n# Query_time: 1\n" + long + "\n# User@Host: b[b] @ h []\n# Query_time: 2\nSELECT 1;\n"
+	got := parseAll(t, log)
+	if len(got) != 2 {
+		t.Fatalf("got %d entries, want 2", len(got))
+	}
+	if len(got[0].query) > maxQuery {
+		t.Errorf("query of %d bytes kept, want at most %d", len(got[0].query), maxQuery)
+	}
+	if got[1].user != "b" || got[1].query != "SELECT 1" {
+		t.Errorf("entry after the long line: %+v", got[1])
+	}
+}
+
+func TestParseUserHost(t *testing.T) {
+	tests := []struct {
+		in, user, host string
+	}{
+		{" app[app] @ web1 [10.0.0.5]", "app", "web1"},
+		{" app[app] @  [10.0.0.6]", "app", "10.0.0.6"},
+		{" root[root] @ localhost []", "root", "localhost"},
+		{" app[app] @ web1 [10.0.0.5]  Id:     8", "app", "web1"},
+	}
+	for _, tt := range tests {
+		user, host := parseUserHost(tt.in)
+		if user != tt.user || host != tt.host {
+			t.Errorf("parseUserHost(%q) = %q, %q, want %q, %q", tt.in, user, host, tt.user, tt.host)
+		}
+	}
+}
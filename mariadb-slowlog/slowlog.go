@@ -0,0 +1,120 @@
+package main
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+var version = flag.Bool("version", false, "Return version")
+var since = flag.String("since", "", "Only digest the statements logged from this time, as 2006-01-02 15:04:05 or a duration before now such as 24h")
+var until = flag.String("until", "", "Only digest the statements logged before this time, same formats as -since")
+var format = flag.String("format", "text", "Output format: text or json")
+var limit = flag.Int("limit", 20, "Number of query classes reported, 0 reports them all")
+var maxClasses = flag.Int("max-classes", 50000, "Most fingerprints tracked, the statements of further fingerprints are only counted in the MISC line (0 is unlimited)")
+var orderBy = flag.String("order-by", "Query_time", "Attribute the classes are ranked by its total, e.g. Query_time, Lock_time, Rows_examined, or count")
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] [slow log files, - or none for stdin]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *version == true {
+		fmt.Println("MariaDB Tools version 0.0.1")
+		os.Exit(0)
+	}
+	if *format != "text" && *format != "json" {
+		log.Fatalf("ERROR: Unknown output format %s, must be text or json", *format)
+	}
+	now := time.Now()
+	from, err := parseBound(*since, now)
+	if err != nil {
+		log.Fatalln("ERROR: Invalid -since", err)
+	}
+	to, err := parseBound(*until, now)
+	if err != nil {
+		log.Fatalln("ERROR: Invalid -until", err)
+	}
+	files := flag.Args()
+	if len(files) == 0 {
+		files = []string{"-"}
+	}
+	a := newAggregator(*maxClasses)
+	for _, name := range files {
+		if err := digestFile(a, name, from, to); err != nil {
+			log.Fatalln("ERROR: Could not read", name, err)
+		}
+	}
+	if err := render(os.Stdout, a, files, *format, *orderBy, *limit); err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+// Streams a log into the aggregator, gzipped logs are read as is.
+func digestFile(a *aggregator, name string, from, to time.Time) error {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	p := newLogParser(r)
+	for {
+		e, err := p.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if e.query == "" || !inRange(e.time, from, to) {
+			continue
+		}
+		a.add(e)
+	}
+}
+
+// Statements without a time are only kept when no range is given.
+func inRange(t, from, to time.Time) bool {
+	if from.IsZero() && to.IsZero() {
+		return true
+	}
+	if t.IsZero() {
+		return false
+	}
+	return !t.Before(from) && (to.IsZero() || t.Before(to))
+}
+
+// Parses a time range bound, either a local date and time or a duration
+// counted back from now.
+func parseBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{"2006-01-02 15:04:05", "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02", time.RFC3339} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%s is neither a date nor a duration", s)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseBound(t *testing.T) {
+	now := time.Date(2023, 10, 18, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		in   string
+		want time.Time
+		err  bool
+	}{
+		{"", time.Time{}, false},
+		{"2h", now.Add(-2 * time.Hour), false},
+		{"2023-10-18 09:05:01", time.Date(2023, 10, 18, 9, 5, 1, 0, time.Local), false},
+		{"2023-10-18T09:05:01", time.Date(2023, 10, 18, 9, 5, 1, 0, time.Local), false},
+		{"2023-10-18 09:05", time.Date(2023, 10, 18, 9, 5, 0, 0, time.Local), false},
+		{"2023-10-18", time.Date(2023, 10, 18, 0, 0, 0, 0, time.Local), false},
+		{"2023-10-18T09:05:01Z", time.Date(2023, 10, 18, 9, 5, 1, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseBound(tt.in, now)
+		if (err != nil) != tt.err || !got.Equal(tt.want) {
+			t.Errorf("parseBound(%q) = %s, %v, want %s, error %v", tt.in, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestInRange(t *testing.T) {
+	from := time.Date(2023, 10, 18, 9, 0, 0, 0, time.UTC)
+	to := from.Add(time.Hour)
+	tests := []struct {
+		name     string
+		t        time.Time
+		from, to time.Time
+		want     bool
+	}{
+		{"no range", time.Time{}, time.Time{}, time.Time{}, true},
+		{"no time with a range", time.Time{}, from, to, false},
+		{"from is included", from, from, to, true},
+		{"to is excluded", to, from, to, false},
+		{"before", from.Add(-time.Second), from, to, false},
+		{"open end", to.Add(time.Hour), from, time.Time{}, true},
+		{"open start", from, time.Time{}, to, true},
+	}
+	for _, tt := range tests {
+		if got := inRange(tt.t, tt.from, tt.to); got != tt.want {
+			t.Errorf("%s: inRange = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// A column displays one status variable, either as a counter (delta between
+// two samples) or as a gauge (current value).
+type column struct {
+	label    string
+	variable string
+	counter  bool
+	divisor  int64
+	width    int
+}
+
+type columnGroup struct {
+	name    string
+	title   string
+	columns []column
+}
+
+var columnGroups = []columnGroup{
+	{"queries", "Queries", []column{
+		{label: "Que", variable: "QUERIES", counter: true},
+		{label: "Sel", variable: "COM_SELECT", counter: true},
+		{label: "Ins", variable: "COM_INSERT", counter: true},
+		{label: "Upd", variable: "COM_UPDATE", counter: true},
+		{label: "Del", variable: "COM_DELETE", counter: true},
+	}},
+	{"txns", "Txns", []column{
+		{label: "Com", variable: "COM_COMMIT", counter: true},
+		{label: "Rbk", variable: "COM_ROLLBACK", counter: true},
+	}},
+	{"threads", "Threads", []column{
+		{label: "Con", variable: "THREADS_CONNECTED"},
+		{label: "Thr", variable: "THREADS_RUNNING"},
+	}},
+	{"aborts", "Aborts", []column{
+		{label: "Cli", variable: "ABORTED_CLIENTS", counter: true},
+		{label: "Con", variable: "ABORTED_CONNECTS", counter: true},
+	}},
+	{"tables", "Tables", []column{
+		{label: "Opn", variable: "OPEN_TABLES"},
+		{label: "Opd", variable: "OPENED_TABLES", counter: true},
+	}},
+	{"files", "Files", []column{
+		{label: "Opn", variable: "OPEN_FILES"},
+		{label: "Opd", variable: "OPENED_FILES", counter: true},
+	}},
+	{"innodb", "InnoDB", []column{
+		{label: "Rreq", variable: "INNODB_BUFFER_POOL_READ_REQUESTS", counter: true},
+		{label: "Rdsk", variable: "INNODB_BUFFER_POOL_READS", counter: true},
+		{label: "Wreq", variable: "INNODB_BUFFER_POOL_WRITE_REQUESTS", counter: true},
+		{label: "Dirty", variable: "INNODB_BUFFER_POOL_PAGES_DIRTY"},
+		{label: "Free", variable: "INNODB_BUFFER_POOL_PAGES_FREE"},
+		{label: "RRd", variable: "INNODB_ROWS_READ", counter: true},
+		{label: "RIns", variable: "INNODB_ROWS_INSERTED", counter: true},
+		{label: "RUpd", variable: "INNODB_ROWS_UPDATED", counter: true},
+		{label: "RDel", variable: "INNODB_ROWS_DELETED", counter: true},
+		{label: "LogW", variable: "INNODB_OS_LOG_WRITTEN", counter: true},
+		{label: "LkW", variable: "INNODB_ROW_LOCK_CURRENT_WAITS"},
+	}},
+	{"replication", "Replication", []column{
+		{label: "BinB", variable: "BINLOG_BYTES_WRITTEN", counter: true},
+		{label: "BCom", variable: "BINLOG_COMMITS", counter: true},
+		{label: "Slv", variable: "SLAVES_CONNECTED"},
+		{label: "SRun", variable: "SLAVES_RUNNING"},
+		{label: "Rtry", variable: "SLAVE_RETRIED_TRANSACTIONS", counter: true},
+		{label: "Hbt", variable: "SLAVE_RECEIVED_HEARTBEATS", counter: true},
+	}},
+	{"network", "Network", []column{
+		{label: "Rcvd", variable: "BYTES_RECEIVED", counter: true},
+		{label: "Sent", variable: "BYTES_SENT", counter: true},
+		{label: "Conn", variable: "CONNECTIONS", counter: true},
+		{label: "MaxU", variable: "MAX_USED_CONNECTIONS"},
+	}},
+	{"handlers", "Handlers", []column{
+		{label: "Rfst", variable: "HANDLER_READ_FIRST", counter: true},
+		{label: "Rkey", variable: "HANDLER_READ_KEY", counter: true},
+		{label: "Rnxt", variable: "HANDLER_READ_NEXT", counter: true},
+		{label: "Rrnd", variable: "HANDLER_READ_RND", counter: true},
+		{label: "Rrnx", variable: "HANDLER_READ_RND_NEXT", counter: true},
+		{label: "Wri", variable: "HANDLER_WRITE", counter: true},
+		{label: "Upd", variable: "HANDLER_UPDATE", counter: true},
+		{label: "Del", variable: "HANDLER_DELETE", counter: true},
+	}},
+	{"tmp", "Tmp tables", []column{
+		{label: "Tbl", variable: "CREATED_TMP_TABLES", counter: true},
+		{label: "Disk", variable: "CREATED_TMP_DISK_TABLES", counter: true},
+		{label: "File", variable: "CREATED_TMP_FILES", counter: true},
+		{label: "SMrg", variable: "SORT_MERGE_PASSES", counter: true},
+	}},
+	{"galera", "Galera", []column{
+		{label: "Size", variable: "WSREP_CLUSTER_SIZE"},
+		{label: "Repl", variable: "WSREP_REPLICATED", counter: true},
+		{label: "Recv", variable: "WSREP_RECEIVED", counter: true},
+		{label: "SQ", variable: "WSREP_LOCAL_SEND_QUEUE"},
+		{label: "RQ", variable: "WSREP_LOCAL_RECV_QUEUE"},
+		{label: "FCms", variable: "WSREP_FLOW_CONTROL_PAUSED_NS", counter: true, divisor: 1e6},
+		{label: "Cert", variable: "WSREP_LOCAL_CERT_FAILURES", counter: true},
+		{label: "BFA", variable: "WSREP_LOCAL_BF_ABORTS", counter: true},
+	}},
+}
+
+// Builds the list of displayed column groups from the -columns and -custom
+// flags. Column widths start from the labels and grow with the values, see
+// fitColumns.
+func selectColumns(names string, custom string) []columnGroup {
+	var sel []columnGroup
+	for _, name := range strings.Split(names, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if name == "all" {
+			sel = append(sel, columnGroups...)
+			continue
+		}
+		found := false
+		for _, g := range columnGroups {
+			if g.name == name {
+				sel = append(sel, g)
+				found = true
+				break
+			}
+		}
+		if !found {
+			log.Fatalf("ERROR: Unknown column group %s, use -list-columns to show available groups", name)
+		}
+	}
+	if custom != "" {
+		g := columnGroup{name: "custom", title: "Custom"}
+		for _, def := range strings.Split(custom, ",") {
+			c, err := parseColumn(def)
+			if err != nil {
+				log.Fatalln("ERROR:", err)
+			}
+			g.columns = append(g.columns, c)
+		}
+		sel = append(sel, g)
+	}
+	if len(sel) == 0 {
+		log.Fatal("ERROR: No columns selected")
+	}
+	for i := range sel {
+		cols := make([]column, len(sel[i].columns))
+		for j, c := range sel[i].columns {
+			c.width = len(c.label)
+			if c.width < 5 {
+				c.width = 5
+			}
+			cols[j] = c
+		}
+		sel[i].columns = cols
+	}
+	return sel
+}
+
+// Parses a user-defined column in the [label=]variable[:counter|gauge] format.
+// Columns default to counters and are labelled with the variable name.
+func parseColumn(def string) (column, error) {
+	def = strings.TrimSpace(def)
+	c := column{counter: true}
+	if i := strings.Index(def, "="); i >= 0 {
+		c.label = def[:i]
+		def = def[i+1:]
+	}
+	if i := strings.Index(def, ":"); i >= 0 {
+		switch strings.ToLower(def[i+1:]) {
+		case "counter":
+			c.counter = true
+		case "gauge":
+			c.counter = false
+		default:
+			return c, fmt.Errorf("unknown column type %s, must be counter or gauge", def[i+1:])
+		}
+		def = def[:i]
+	}
+	if def == "" {
+		return c, fmt.Errorf("no status variable in column definition")
+	}
+	c.variable = strings.ToUpper(def)
+	if c.label == "" {
+		c.label = strings.ToLower(def)
+	}
+	return c, nil
+}
+
+func listColumns() {
+	for _, g := range columnGroups {
+		fmt.Printf("%-12s", g.name)
+		for i, c := range g.columns {
+			if i > 0 {
+				fmt.Print(", ")
+			}
+			t := "gauge"
+			if c.counter {
+				t = "counter"
+			}
+			fmt.Printf("%s=%s:%s", c.label, strings.ToLower(c.variable), t)
+		}
+		fmt.Println()
+	}
+}
+
+func groupWidth(g columnGroup) int {
+	w := len(g.columns) - 1
+	for _, c := range g.columns {
+		w += c.width
+	}
+	return w
+}
+
+// Widens the columns too narrow for the values of the samples, returns true
+// when they changed and the header must be printed again.
+func fitColumns(sel []columnGroup, samples []*sample) bool {
+	changed := false
+	for _, g := range sel {
+		for j := range g.columns {
+			c := &g.columns[j]
+			for _, s := range samples {
+				if s.stale {
+					continue
+				}
+				if n := len(strconv.FormatInt(s.value(*c), 10)); n > c.width {
+					c.width = n
+					changed = true
+				}
+			}
+		}
+	}
+	return changed
+}
+
+// Prints the group titles above their columns, then the column labels.
+// hostWidth reserves a leading host column when several servers are shown.
+func printHeader(w io.Writer, sel []columnGroup, hostWidth int) {
+	var titles, labels []string
+	if hostWidth > 0 {
+		titles = append(titles, fmt.Sprintf("%-*s", hostWidth, ""))
+		labels = append(labels, fmt.Sprintf("%-*s", hostWidth, "Host"))
+	}
+	for _, g := range sel {
+		gw := groupWidth(g)
+		title := g.title
+		if len(title) > gw {
+			title = title[:gw]
+		}
+		titles = append(titles, fmt.Sprintf("%-*s", gw, title))
+		for _, c := range g.columns {
+			labels = append(labels, fmt.Sprintf("%*s", c.width, c.label))
+		}
+	}
+	fmt.Fprintln(w, strings.TrimRight(strings.Join(titles, " "), " "))
+	fmt.Fprintln(w, strings.Join(labels, " "))
+}
+
+// Prints one row of values. Values deviating from the other servers are
+// highlighted when color is set.
+func printValues(w io.Writer, sel []columnGroup, s *sample, hostWidth int, color bool) {
+	var values []string
+	if hostWidth > 0 {
+		h := s.host
+		if len(h) > hostWidth {
+			h = h[:hostWidth]
+		}
+		values = append(values, fmt.Sprintf("%-*s", hostWidth, h))
+	}
+	for _, g := range sel {
+		for _, c := range g.columns {
+			if s.stale {
+				values = append(values, fmt.Sprintf("%*s", c.width, "-"))
+				continue
+			}
+			v := fmt.Sprintf("%*d", c.width, s.value(c))
+			if color && s.deviating[c.variable] {
+				v = "\033[1;31m" + v + "\033[0m"
+			}
+			values = append(values, v)
+		}
+	}
+	fmt.Fprintln(w, strings.Join(values, " "))
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxWriter batches status samples as InfluxDB line protocol and posts them
+// to either the v2 (/api/v2/write) or the v1 (/write) endpoint.
+type influxWriter struct {
+	url      string
+	api      string
+	org      string
+	bucket   string
+	token    string
+	database string
+	user     string
+	password string
+	batch    int
+	buffer   int
+	tags     string
+	lines    []string
+	client   *http.Client
+}
+
+func newInfluxWriter(tags map[string]string) *influxWriter {
+	w := &influxWriter{
+		url:      strings.TrimRight(*influxURL, "/"),
+		api:      *influxAPI,
+		org:      *influxOrg,
+		bucket:   *influxBucket,
+		token:    *influxToken,
+		database: *influxDB,
+		user:     *influxUser,
+		password: *influxPassword,
+		batch:    *influxBatch,
+		buffer:   *influxBuffer,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	if w.batch < 1 {
+		w.batch = 1
+	}
+	if w.buffer < w.batch {
+		w.buffer = w.batch
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if tags[k] == "" {
+			continue
+		}
+		w.tags += "," + escapeKey(k) + "=" + escapeKey(tags[k])
+	}
+	return w
+}
+
+// Queues one sample and writes the pending lines once a full batch is ready.
+// Integer values are written as integer fields and decimal ones as floats,
+// non numeric statuses such as wsrep_cluster_status are left out.
+func (w *influxWriter) add(measurement string, fields map[string]string, t time.Time) {
+	values := make(map[string]string, len(fields))
+	keys := make([]string, 0, len(fields))
+	for k, v := range fields {
+		if _, err := strconv.ParseInt(v, 10, 64); err == nil {
+			values[k] = v + "i"
+		} else if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			values[k] = strconv.FormatFloat(f, 'f', -1, 64)
+		} else {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	if len(keys) == 0 {
+		return
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(escapeMeasurement(measurement))
+	b.WriteString(w.tags)
+	b.WriteByte(' ')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%s", escapeKey(strings.ToLower(k)), values[k])
+	}
+	fmt.Fprintf(&b, " %d", t.Unix())
+	w.lines = append(w.lines, b.String())
+	if len(w.lines) > w.buffer {
+		dropped := len(w.lines) - w.buffer
+		log.Printf("WARN : InfluxDB buffer full, dropping %d oldest samples", dropped)
+		w.lines = w.lines[dropped:]
+	}
+	if len(w.lines) >= w.batch {
+		if err := w.flush(); err != nil {
+			log.Printf("WARN : Could not write to InfluxDB, %d samples buffered: %s", len(w.lines), err)
+		}
+	}
+}
+
+// Writes all buffered lines in batches. Lines that could not be written are
+// kept so they can be retried on the next call.
+func (w *influxWriter) flush() error {
+	for len(w.lines) > 0 {
+		n := w.batch
+		if n > len(w.lines) {
+			n = len(w.lines)
+		}
+		if err := w.post(strings.Join(w.lines[:n], "\n") + "\n"); err != nil {
+			return err
+		}
+		w.lines = w.lines[n:]
+	}
+	return nil
+}
+
+func (w *influxWriter) post(payload string) error {
+	req, err := http.NewRequest("POST", w.endpoint(), bytes.NewBufferString(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if w.api == "v2" && w.token != "" {
+		req.Header.Set("Authorization", "Token "+w.token)
+	}
+	r, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode < 200 || r.StatusCode > 299 {
+		response, _ := ioutil.ReadAll(r.Body)
+		return fmt.Errorf("%s: %s", r.Status, strings.TrimSpace(string(response)))
+	}
+	return nil
+}
+
+func (w *influxWriter) endpoint() string {
+	q := url.Values{}
+	q.Set("precision", "s")
+	if w.api == "v2" {
+		q.Set("org", w.org)
+		q.Set("bucket", w.bucket)
+		return w.url + "/api/v2/write?" + q.Encode()
+	}
+	q.Set("db", w.database)
+	if w.user != "" {
+		q.Set("u", w.user)
+		q.Set("p", w.password)
+	}
+	return w.url + "/write?" + q.Encode()
+}
+
+var measurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+var keyEscaper = strings.NewReplacer(",", `\,`, " ", `\ `, "=", `\=`)
+
+func escapeMeasurement(s string) string {
+	return measurementEscaper.Replace(s)
+}
+
+// Escapes tag keys, tag values and field keys.
+func escapeKey(s string) string {
+	return keyEscaper.Replace(s)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInfluxWrite(t *testing.T) {
+	tests := []struct {
+		name    string
+		api     string
+		tags    map[string]string
+		fields  map[string]string
+		path    string
+		query   map[string]string
+		auth    string
+		payload string
+	}{
+		{
+			name:    "v2",
+			api:     "v2",
+			tags:    map[string]string{"host": "db1", "port": "3306"},
+			fields:  map[string]string{"QUESTIONS": "42", "THREADS_RUNNING": "3"},
+			path:    "/api/v2/write",
+			query:   map[string]string{"org": "acme", "bucket": "mariadb", "precision": "s"},
+			auth:    "Token secret",
+			payload: "mariadb_status,host=db1,port=3306 questions=42i,threads_running=3i 1700000000\n",
+		},
+		{
+			name:    "v1",
+			api:     "v1",
+			tags:    map[string]string{"host": "db1"},
+			fields:  map[string]string{"UPTIME": "10"},
+			path:    "/write",
+			query:   map[string]string{"db": "mariadb", "u": "admin", "p": "pass", "precision": "s"},
+			payload: "mariadb_status,host=db1 uptime=10i 1700000000\n",
+		},
+		{
+			name:    "escaping",
+			api:     "v1",
+			tags:    map[string]string{"host": "db 1,eu=west", "empty": ""},
+			fields:  map[string]string{"a b,c=d": "1"},
+			path:    "/write",
+			query:   map[string]string{"db": "mariadb"},
+			payload: `mariadb_status,host=db\ 1\,eu\=west a\ b\,c\=d=1i 1700000000` + "\n",
+		},
+		{
+			name:    "non numeric",
+			api:     "v1",
+			tags:    map[string]string{"host": "db1"},
+			fields:  map[string]string{"WSREP_CLUSTER_STATUS": "Primary", "SLAVE_RUNNING": "OFF", "WSREP_FLOW_CONTROL_PAUSED": "0.125", "UPTIME": "10", "EMPTY": ""},
+			path:    "/write",
+			query:   map[string]string{"db": "mariadb"},
+			payload: "mariadb_status,host=db1 uptime=10i,wsrep_flow_control_paused=0.125 1700000000\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			var body string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				got, body = r, string(b)
+				w.WriteHeader(http.StatusNoContent)
+			}))
+			defer srv.Close()
+			*influxURL, *influxAPI, *influxOrg, *influxToken = srv.URL, tt.api, "acme", "secret"
+			*influxUser, *influxPassword, *influxBatch = "", "", 1
+			if tt.query["u"] != "" {
+				*influxUser, *influxPassword = tt.query["u"], tt.query["p"]
+			}
+			w := newInfluxWriter(tt.tags)
+			w.add("mariadb_status", tt.fields, time.Unix(1700000000, 0))
+			if got == nil {
+				t.Fatal("no write received")
+			}
+			if got.URL.Path != tt.path {
+				t.Errorf("path = %s, want %s", got.URL.Path, tt.path)
+			}
+			for k, v := range tt.query {
+				if q := got.URL.Query().Get(k); q != v {
+					t.Errorf("query %s = %q, want %q", k, q, v)
+				}
+			}
+			if a := got.Header.Get("Authorization"); a != tt.auth {
+				t.Errorf("Authorization = %q, want %q", a, tt.auth)
+			}
+			if body != tt.payload {
+				t.Errorf("payload = %q, want %q", body, tt.payload)
+			}
+			if len(w.lines) != 0 {
+				t.Errorf("%d lines left in the buffer", len(w.lines))
+			}
+		})
+	}
+}
+
+func TestInfluxNothingNumeric(t *testing.T) {
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writes++
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	*influxURL, *influxAPI, *influxBatch = srv.URL, "v1", 1
+	w := newInfluxWriter(nil)
+	w.add("m", map[string]string{"WSREP_CLUSTER_STATUS": "Primary"}, time.Unix(0, 0))
+	if writes != 0 || len(w.lines) != 0 {
+		t.Errorf("%d writes and %d lines for a sample without numbers", writes, len(w.lines))
+	}
+}
+
+func TestInfluxRetry(t *testing.T) {
+	fail := true
+	writes := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writes++
+		if fail {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+	*influxURL, *influxAPI, *influxBatch, *influxBuffer = srv.URL, "v1", 1, 2
+	w := newInfluxWriter(nil)
+	for i := 0; i < 3; i++ {
+		w.add("m", map[string]string{"v": strconv.Itoa(i)}, time.Unix(int64(i), 0))
+	}
+	if len(w.lines) != 2 {
+		t.Fatalf("%d lines buffered, want the buffer size 2", len(w.lines))
+	}
+	fail = false
+	if err := w.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.lines) != 0 || writes != 5 {
+		t.Errorf("%d lines left and %d writes, want 0 and 5", len(w.lines), writes)
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"log"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// server polls the status of one host in its own goroutine, so that a slow or
+// unreachable server never delays the rows of the others.
+type server struct {
+	name     string
+	db       *sqlx.DB
+	mu       sync.Mutex
+	status   map[string]int64
+	prev     map[string]int64
+	time     time.Time
+	prevTime time.Time
+	fresh    bool
+}
+
+func (s *server) poll(d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		st, err := dbhelper.GetStatusAsIntErr(s.db)
+		t := time.Now()
+		if err != nil {
+			log.Printf("WARN : Could not get status from %s: %s", s.name, err)
+		} else {
+			s.mu.Lock()
+			s.prev, s.prevTime = s.status, s.time
+			s.status, s.time = st, t
+			s.fresh = s.prev != nil
+			s.mu.Unlock()
+		}
+		<-ticker.C
+	}
+}
+
+// Returns the last sample of the server, marked stale when no new status was
+// read since the previous call.
+func (s *server) sample() *sample {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.fresh {
+		return &sample{host: s.name, stale: true}
+	}
+	s.fresh = false
+	return &sample{host: s.name, time: s.time, elapsed: s.time.Sub(s.prevTime).Seconds(), status: s.status, prev: s.prev}
+}
+
+func monitorServers(sel []columnGroup, list []string) {
+	if *interval < 1 {
+		log.Fatal("ERROR: Interval must be at least one second when monitoring several servers")
+	}
+	d := time.Duration(*interval) * time.Second
+	var servers []*server
+	// log.Fatal skips the deferred calls, the error paths close the
+	// connections first
+	closeAll := func() {
+		for _, s := range servers {
+			s.db.Close()
+		}
+	}
+	defer closeAll()
+	hostWidth := 4
+	for _, h := range list {
+		h = strings.TrimSpace(h)
+		if h == "" {
+			continue
+		}
+		hostname, hostport := h, *port
+		if hh, pp, err := net.SplitHostPort(h); err == nil {
+			hostname, hostport = hh, pp
+		}
+		db, err := sqlx.Open("mysql", *user+":"+*password+"@"+dbhelper.GetAddress(hostname, hostport, "")+"/?timeout=5s&readTimeout="+d.String())
+		if err != nil {
+			closeAll()
+			log.Fatal(err)
+		}
+		db.SetMaxOpenConns(1)
+		servers = append(servers, &server{name: h, db: db})
+		if len(h) > hostWidth {
+			hostWidth = len(h)
+		}
+	}
+	if len(servers) == 0 {
+		log.Fatal("ERROR: No hosts specified")
+	}
+	if hostWidth > 20 {
+		hostWidth = 20
+	}
+
+	out, err := newSampleWriter(*format, *output, *rotateSize*1024*1024, *rotateKeep)
+	if err != nil {
+		closeAll()
+		log.Fatalln("ERROR:", err)
+	}
+	defer out.close()
+	out.hostWidth = hostWidth
+	if fi, err := os.Stdout.Stat(); err == nil && *output == "" && fi.Mode()&os.ModeCharDevice != 0 {
+		out.color = true
+	}
+
+	for _, s := range servers {
+		go s.poll(d)
+	}
+	// Read the samples half way between two polls.
+	time.Sleep(d + d/2)
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		samples := make([]*sample, len(servers))
+		for i, s := range servers {
+			samples[i] = s.sample()
+		}
+		if *sum {
+			samples = []*sample{sumSamples(samples)}
+		} else if *deviation > 1 {
+			markDeviations(sel, samples, *deviation)
+		}
+		if err := out.write(sel, samples); err != nil {
+			closeAll()
+			log.Fatalln("ERROR: Could not write sample", err)
+		}
+		<-ticker.C
+	}
+}
+
+// Adds up the status of all servers that returned a fresh sample. The counters
+// are added up as rates, each server being polled over its own elapsed time.
+func sumSamples(samples []*sample) *sample {
+	total := &sample{status: make(map[string]int64), rates: make(map[string]float64)}
+	n := 0
+	for _, s := range samples {
+		if s.stale {
+			continue
+		}
+		for k, v := range s.status {
+			total.status[k] += v
+			total.rates[k] += s.perSecond(k)
+		}
+		if s.time.After(total.time) {
+			total.time = s.time
+		}
+		n++
+	}
+	if n == 0 {
+		return &sample{host: "cluster", stale: true}
+	}
+	total.host = "cluster"
+	if n < len(samples) {
+		total.host = "cluster " + strconv.Itoa(n) + "/" + strconv.Itoa(len(samples))
+	}
+	return total
+}
+
+// Flags values that are more than factor times above or below the median of
+// all servers. At least three servers are needed for a meaningful median, and
+// small values are ignored to avoid flagging noise.
+func markDeviations(sel []columnGroup, samples []*sample, factor float64) {
+	var fresh []*sample
+	for _, s := range samples {
+		if !s.stale {
+			fresh = append(fresh, s)
+			s.deviating = make(map[string]bool)
+		}
+	}
+	if len(fresh) < 3 {
+		return
+	}
+	for _, g := range sel {
+		for _, c := range g.columns {
+			values := make([]float64, len(fresh))
+			for i, s := range fresh {
+				values[i] = float64(s.value(c))
+			}
+			sorted := append([]float64(nil), values...)
+			sort.Float64s(sorted)
+			median := sorted[len(sorted)/2]
+			if len(sorted)%2 == 0 {
+				median = (sorted[len(sorted)/2-1] + median) / 2
+			}
+			for i, v := range values {
+				if v < 10 && median < 10 {
+					continue
+				}
+				if v > median*factor || v*factor < median {
+					fresh[i].deviating[c.variable] = true
+				}
+			}
+		}
+	}
+}
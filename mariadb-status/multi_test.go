@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSumSamples(t *testing.T) {
+	*interval, *average = 10, false
+	now := time.Unix(1700000000, 0)
+	samples := []*sample{
+		// 100 queries over the 10s interval
+		{host: "db1", time: now, elapsed: 10, status: map[string]int64{"QUERIES": 1100, "THREADS_RUNNING": 3}, prev: map[string]int64{"QUERIES": 1000}},
+		// 100 queries over 20s after a missed poll, half the rate of db1
+		{host: "db2", time: now.Add(time.Second), elapsed: 20, status: map[string]int64{"QUERIES": 600, "THREADS_RUNNING": 2}, prev: map[string]int64{"QUERIES": 500}},
+		{host: "db3", stale: true},
+	}
+	total := sumSamples(samples)
+	queries := column{variable: "QUERIES", counter: true}
+	running := column{variable: "THREADS_RUNNING"}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"host", total.host, "cluster 2/3"},
+		{"time", total.time, now.Add(time.Second)},
+		{"counter", total.value(queries), int64(150)},
+		{"rate", total.perSecond("QUERIES"), 15.0},
+		{"gauge", total.value(running), int64(5)},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+	if s := sumSamples([]*sample{{host: "db1", stale: true}}); !s.stale {
+		t.Errorf("sum of stale samples is not stale")
+	}
+}
+
+func TestMarkDeviations(t *testing.T) {
+	*interval, *average = 1, false
+	running := column{variable: "THREADS_RUNNING"}
+	sel := []columnGroup{{columns: []column{running}}}
+	gauge := func(host string, v int64) *sample {
+		return &sample{host: host, elapsed: 1, status: map[string]int64{"THREADS_RUNNING": v}, prev: map[string]int64{}}
+	}
+	tests := []struct {
+		name    string
+		samples []*sample
+		want    []bool
+	}{
+		{"two servers are not compared", []*sample{gauge("a", 10), gauge("b", 1000)}, []bool{false, false}},
+		{"above the median", []*sample{gauge("a", 20), gauge("b", 25), gauge("c", 100)}, []bool{false, false, true}},
+		{"below the median", []*sample{gauge("a", 2), gauge("b", 25), gauge("c", 30)}, []bool{true, false, false}},
+		{"small values are noise", []*sample{gauge("a", 1), gauge("b", 9), gauge("c", 0)}, []bool{false, false, false}},
+		{"stale servers are left out", []*sample{gauge("a", 20), {host: "b", stale: true}, gauge("c", 100)}, []bool{false, false, false}},
+	}
+	for _, tt := range tests {
+		markDeviations(sel, tt.samples, 3)
+		for i, s := range tt.samples {
+			if s.deviating["THREADS_RUNNING"] != tt.want[i] {
+				t.Errorf("%s: %s deviating = %v, want %v", tt.name, s.host, !tt.want[i], tt.want[i])
+			}
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sampleWriter writes status samples in text, csv, tsv or json (JSON lines)
+// format to stdout or to a file, rotating the file once it grows past maxSize.
+type sampleWriter struct {
+	format    string
+	path      string
+	maxSize   int64
+	keep      int
+	out       io.Writer
+	file      *os.File
+	size      int64
+	rows      uint64
+	hostWidth int
+	color     bool
+}
+
+func newSampleWriter(format string, path string, maxSize int64, keep int) (*sampleWriter, error) {
+	switch format {
+	case "text", "csv", "tsv", "json":
+	default:
+		return nil, fmt.Errorf("unknown output format %s, must be text, csv, tsv or json", format)
+	}
+	w := &sampleWriter{format: format, path: path, maxSize: maxSize, keep: keep, out: os.Stdout}
+	if path != "" {
+		if err := w.open(); err != nil {
+			return nil, err
+		}
+	}
+	return w, nil
+}
+
+func (w *sampleWriter) open() error {
+	f, err := os.OpenFile(w.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	w.file = f
+	w.out = f
+	w.size = fi.Size()
+	w.rows = 0
+	return nil
+}
+
+// Renames file to file.1, file.1 to file.2 and so on, removing the oldest
+// file when more than keep rotated files exist, then reopens the file.
+func (w *sampleWriter) rotate() error {
+	w.file.Close()
+	if w.keep > 0 {
+		os.Remove(fmt.Sprintf("%s.%d", w.path, w.keep))
+		for i := w.keep - 1; i > 0; i-- {
+			os.Rename(fmt.Sprintf("%s.%d", w.path, i), fmt.Sprintf("%s.%d", w.path, i+1))
+		}
+		if err := os.Rename(w.path, w.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(w.path); err != nil {
+		return err
+	}
+	return w.open()
+}
+
+func (w *sampleWriter) Write(p []byte) (int, error) {
+	n, err := w.out.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *sampleWriter) close() {
+	if w.file != nil {
+		w.file.Close()
+	}
+}
+
+// Writes the samples taken during one interval, one row per server.
+func (w *sampleWriter) write(sel []columnGroup, samples []*sample) error {
+	if w.file != nil && w.maxSize > 0 && w.size >= w.maxSize {
+		if err := w.rotate(); err != nil {
+			return err
+		}
+	}
+	var err error
+	switch w.format {
+	case "text":
+		if fitColumns(sel, samples) || (w.rows%10) == 0 {
+			printHeader(w, sel, w.hostWidth)
+		}
+		for _, s := range samples {
+			printValues(w, sel, s, w.hostWidth, w.color)
+		}
+	case "csv", "tsv":
+		err = w.writeCSV(sel, samples)
+	case "json":
+		err = w.writeJSON(sel, samples)
+	}
+	w.rows++
+	return err
+}
+
+func (w *sampleWriter) writeCSV(sel []columnGroup, samples []*sample) error {
+	cw := csv.NewWriter(w)
+	if w.format == "tsv" {
+		cw.Comma = '\t'
+	}
+	if w.rows == 0 && w.size == 0 {
+		header := []string{"timestamp"}
+		if w.hostWidth > 0 {
+			header = append(header, "host")
+		}
+		for _, g := range sel {
+			for _, c := range g.columns {
+				name := strings.ToLower(c.variable)
+				header = append(header, name)
+				if c.counter {
+					header = append(header, name+"_per_sec")
+				}
+			}
+		}
+		cw.Write(header)
+	}
+	for _, s := range samples {
+		if s.stale {
+			continue
+		}
+		record := []string{s.time.Format(time.RFC3339)}
+		if w.hostWidth > 0 {
+			record = append(record, s.host)
+		}
+		for _, g := range sel {
+			for _, c := range g.columns {
+				record = append(record, strconv.FormatInt(s.status[c.variable], 10))
+				if c.counter {
+					record = append(record, formatRate(s.perSecond(c.variable)))
+				}
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// JSON lines keep the column order of the text output, so the object is
+// assembled by hand rather than marshalled from a map.
+func (w *sampleWriter) writeJSON(sel []columnGroup, samples []*sample) error {
+	var b strings.Builder
+	for _, s := range samples {
+		if s.stale {
+			continue
+		}
+		ts, _ := json.Marshal(s.time.Format(time.RFC3339))
+		b.WriteString(`{"timestamp":`)
+		b.Write(ts)
+		if w.hostWidth > 0 {
+			h, _ := json.Marshal(s.host)
+			b.WriteString(`,"host":`)
+			b.Write(h)
+		}
+		for _, g := range sel {
+			for _, c := range g.columns {
+				name, _ := json.Marshal(strings.ToLower(c.variable))
+				b.WriteByte(',')
+				b.Write(name)
+				b.WriteString(`:{"raw":`)
+				b.WriteString(strconv.FormatInt(s.status[c.variable], 10))
+				if c.counter {
+					b.WriteString(`,"per_sec":`)
+					b.WriteString(formatRate(s.perSecond(c.variable)))
+				}
+				if s.deviating[c.variable] {
+					b.WriteString(`,"deviating":true`)
+				}
+				b.WriteByte('}')
+			}
+		}
+		b.WriteString("}\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func formatRate(f float64) string {
+	return strconv.FormatFloat(f, 'f', 2, 64)
+}
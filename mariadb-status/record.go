@@ -0,0 +1,49 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/snapshot"
+)
+
+// Records a snapshot every interval until count snapshots are written or the
+// command is interrupted, then closes the file so it ends cleanly.
+func recordSnapshots(db *sqlx.DB, path string, count int) {
+	w, err := snapshot.Create(path)
+	if err != nil {
+		log.Fatalln("ERROR: Could not create snapshot file", err)
+	}
+	if *interval < 1 {
+		log.Fatal("ERROR: Recording interval must be at least one second")
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	ticker := time.NewTicker(time.Duration(*interval) * time.Second)
+	defer ticker.Stop()
+loop:
+	for n := 0; count == 0 || n < count; n++ {
+		if n > 0 {
+			select {
+			case <-ticker.C:
+			case <-sig:
+				break loop
+			}
+		}
+		s, err := snapshot.Take(db)
+		if err != nil {
+			log.Println("WARN : Incomplete snapshot", err)
+		}
+		if err = w.Write(s); err != nil {
+			w.Close()
+			log.Fatalln("ERROR: Could not write snapshot", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		log.Fatalln("ERROR: Could not close snapshot file", err)
+	}
+}
@@ -1,44 +1,54 @@
 package main
 
 import (
-	"bytes"
 	_ "database/sql"
-	"encoding/json"
 	"flag"
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
-	"github.com/tanji/mariadb-tools/dbhelper"
-	"io/ioutil"
+	"github.com/tanji/mariadb-tools/snapshot"
+	"io"
 	"log"
-	"net/http"
+	"math"
 	"os"
 	"strings"
 	"time"
 )
 
-var status map[string]int64
-var prevStatus map[string]int64
-var variable map[string]string
-
-type timeSeries struct {
-	Name    string
-	Columns []string
-	Points  [][]int64
-}
-
 var version = flag.Bool("version", false, "Return version")
 var user = flag.String("user", "", "User for MariaDB login")
 var password = flag.String("password", "", "Password for MariaDB login")
 var host = flag.String("host", "", "MariaDB host IP address or FQDN")
 var socket = flag.String("socket", "/var/run/mysqld/mysqld.sock", "Path of MariaDB unix socket")
 var port = flag.String("port", "3306", "TCP Port of MariaDB server")
-var influxDB = flag.String("influxdb", "mariadb", "InfluxDB database name")
+var influxURL = flag.String("influxdb-url", "http://localhost:8086", "InfluxDB base URL")
+var influxAPI = flag.String("influxdb-api", "v2", "InfluxDB write API version: v2 (/api/v2/write) or v1 (/write)")
+var influxOrg = flag.String("influxdb-org", "", "InfluxDB organization (v2 API)")
+var influxBucket = flag.String("influxdb-bucket", "mariadb", "InfluxDB bucket (v2 API)")
+var influxToken = flag.String("influxdb-token", "", "InfluxDB API token (v2 API)")
+var influxDB = flag.String("influxdb", "mariadb", "InfluxDB database name (v1 API)")
+var influxUser = flag.String("influxdb-user", "", "InfluxDB user (v1 API)")
+var influxPassword = flag.String("influxdb-password", "", "InfluxDB password (v1 API)")
+var influxBatch = flag.Int("influxdb-batch", 10, "Number of samples sent per InfluxDB write")
+var influxBuffer = flag.Int("influxdb-buffer", 3600, "Maximum number of samples kept while InfluxDB is unavailable")
 
 // Options specific to this command follow
 var interval = flag.Int64("interval", 1, "Sleep interval for repeated commands")
 var average = flag.Bool("average", false, "Average per second status data instead of aggregate")
 var collect = flag.Bool("collect", false, "Collect data to an influxdb instance (experimental)")
+var columns = flag.String("columns", "queries,txns,threads,aborts,tables,files", "Comma separated list of column groups to display, or all")
+var custom = flag.String("custom", "", "Comma separated list of extra columns in the [label=]variable[:counter|gauge] format")
+var listCols = flag.Bool("list-columns", false, "List available column groups and exit")
+var format = flag.String("format", "text", "Output format: text, csv, tsv or json (JSON lines)")
+var output = flag.String("output", "", "Write samples to this file instead of stdout")
+var rotateSize = flag.Int64("rotate-size", 0, "Rotate the output file when it exceeds this size in MiB (0 disables rotation)")
+var rotateKeep = flag.Int("rotate-keep", 5, "Number of rotated output files to keep")
+var record = flag.String("record", "", "Record status, variables, processlist and InnoDB status snapshots to this file")
+var count = flag.Int("count", 0, "Number of snapshots to record (0 records until interrupted)")
+var hosts = flag.String("hosts", "", "Comma separated list of host[:port] to monitor concurrently, one row per server")
+var sum = flag.Bool("sum", false, "With -hosts, print one summed cluster row instead of one row per server")
+var deviation = flag.Float64("deviation", 3, "With -hosts and at least 3 servers, highlight values more than this factor above or below the median of all servers (0 disables)")
+var replay = flag.String("replay", "", "Replay a snapshot file recorded with -record instead of connecting to a server")
 
 func main() {
 
@@ -47,86 +57,180 @@ func main() {
 		fmt.Println("MariaDB Tools version 0.0.1")
 		os.Exit(0)
 	}
-	var address string
-	if *socket != "" {
-		address = "unix(" + *socket + ")"
+	if *listCols == true {
+		listColumns()
+		os.Exit(0)
 	}
-	if *host != "" {
-		address = "tcp(" + *host + ":" + *port + ")"
+	sel := selectColumns(*columns, *custom)
+	if *hosts != "" {
+		if *replay != "" || *record != "" || *collect == true {
+			log.Fatal("ERROR: -hosts cannot be combined with -replay, -record or -collect")
+		}
+		monitorServers(sel, strings.Split(*hosts, ","))
+		return
 	}
+	var src snapshot.Source
+	if *replay != "" {
+		r, err := snapshot.OpenReplay(*replay)
+		if err != nil {
+			log.Fatalln("ERROR: Could not open snapshot file", err)
+		}
+		defer r.Close()
+		src = r
+	} else {
+		var address string
+		if *socket != "" {
+			address = "unix(" + *socket + ")"
+		}
+		if *host != "" {
+			address = "tcp(" + *host + ":" + *port + ")"
+		}
 
-	// Create the database handle, confirm driver is present
-	db, _ := sqlx.Open("mysql", *user+":"+*password+"@"+address+"/")
-	err := db.Ping()
-	if err != nil {
-		log.Fatal(err)
-	}
+		// Create the database handle, confirm driver is present
+		db, _ := sqlx.Open("mysql", *user+":"+*password+"@"+address+"/")
+		err := db.Ping()
+		if err != nil {
+			log.Fatal(err)
+		}
 
-	defer db.Close()
+		defer db.Close()
+
+		if *record != "" {
+			recordSnapshots(db, *record, *count)
+			return
+		}
+		src = snapshot.NewLiveSource(db)
+	}
 
 	if *collect == true {
+		if *influxAPI != "v1" && *influxAPI != "v2" {
+			log.Fatalf("ERROR: Unknown InfluxDB API version %s", *influxAPI)
+		}
+		if *influxAPI == "v2" && *influxOrg == "" {
+			log.Fatalln("ERROR: The InfluxDB v2 API needs an organization, set -influxdb-org")
+		}
+		// The port only identifies servers reached over TCP
+		tags := map[string]string{"host": *host, "port": *port}
+		if *host == "" {
+			tags["host"], _ = os.Hostname()
+			delete(tags, "port")
+		}
+		influx := newInfluxWriter(tags)
 		for {
-			status = dbhelper.GetStatusAsInt(db)
-			columns := make([]string, len(status))
-			values := make([]int64, len(status))
-			i := 0
-			for k, v := range status {
-				columns[i] = strings.ToLower(k)
-				values[i] = v
-				i++
+			err := src.Refresh()
+			if err == io.EOF {
+				break
 			}
-			storeStatus(*host+"_status", columns, [][]int64{values})
-			time.Sleep(time.Duration(*interval) * time.Second)
+			if err != nil {
+				log.Fatalln("ERROR: Could not read snapshot", err)
+			}
+			influx.add("mariadb_status", src.GetStatus(), src.Now())
+			if src.Live() {
+				time.Sleep(time.Duration(*interval) * time.Second)
+			}
+		}
+		if err := influx.flush(); err != nil {
+			log.Fatalln("ERROR: Could not write to InfluxDB", err)
 		}
 
 	} else {
 
-		status = dbhelper.GetStatusAsInt(db)
+		out, err := newSampleWriter(*format, *output, *rotateSize*1024*1024, *rotateKeep)
+		if err != nil {
+			log.Fatalln("ERROR:", err)
+		}
+		defer out.close()
+
+		st, ok := nextSample(src)
+		if !ok {
+			return
+		}
+		s := &sample{time: src.Now(), status: st}
 
-		var iter uint64 = 0
 		for {
-			if (iter % 10) == 0 {
-				fmt.Printf("  %-30s%-10s  %-10s  %-10s  %-10s  %-10s\n", "Queries", "Txns", "Threads", "Aborts", "Tables", "Files")
-			}
-			prevStatus = status
-			status = dbhelper.GetStatusAsInt(db)
-			fmt.Printf("%5s %5s %5s %5s %5s %5s %5s %5s %5s %5s %5s %5s %5s %5s %5s\n", "Que", "Sel", "Ins", "Upd", "Del", "Com", "Rbk", "Con", "Thr", "Cli", "Con", "Opn", "Opd", "Opn", "Opd")
-			fmt.Printf("%5d %5d %5d %5d %5d %5d %5d %5d %5d %5d %5d %5d %5d %5d %5d\n", getCounter("QUERIES"), getCounter("COM_SELECT"), getCounter("COM_INSERT"), getCounter("COM_UPDATE"), getCounter("COM_DELETE"),
-				getCounter("COM_COMMIT"), getCounter("COM_ROLLBACK"), getStatic("THREADS_CONNECTED"), getStatic("THREADS_RUNNING"), getCounter("ABORTED_CLIENTS"), getCounter("ABORTED_CONNECTS"),
-				getStatic("OPEN_TABLES"), getCounter("OPENED_TABLES"), getStatic("OPEN_FILES"), getCounter("OPENED_FILES"))
 			time.Sleep(time.Duration(*interval) * time.Second)
-			iter++
+			st, ok = nextSample(src)
+			if !ok {
+				break
+			}
+			t := src.Now()
+			s = &sample{time: t, elapsed: t.Sub(s.time).Seconds(), status: st, prev: s.status}
+			err = out.write(sel, []*sample{s})
+			if err != nil {
+				log.Fatalln("ERROR: Could not write sample", err)
+			}
 		}
 	}
 }
 
-// Stores the status values in a InfluxDB instance.
-func storeStatus(n string, c []string, p [][]int64) {
-	ts := timeSeries{n, c, p}
-	b, err := json.Marshal(ts)
-	js := string(b)
-	js = "[" + js + "]"
-	b = []byte(js)
-	if err != nil {
-		log.Fatal(err)
-	}
-	body := bytes.NewBuffer(b)
-	r, _ := http.Post("http://localhost:8086/db/"+*influxDB+"/series?u=root&p=root", "text/json", body)
-	response, _ := ioutil.ReadAll(r.Body)
-	if response != nil {
-		fmt.Println(string(response))
+// Reads the next status sample, returns false when a replayed file is over.
+// Recorded snapshots whose status could not be read are skipped.
+func nextSample(src snapshot.Source) (map[string]int64, bool) {
+	for {
+		err := src.Refresh()
+		if err == io.EOF {
+			return nil, false
+		}
+		if err != nil {
+			log.Fatalln("ERROR: Could not read snapshot", err)
+		}
+		if st := src.GetStatusAsInt(); st != nil {
+			return st, true
+		}
 	}
 }
 
-// Get a counter from the recorded status values.
-func getCounter(s string) int64 {
+// A sample holds two consecutive status readings of one server.
+type sample struct {
+	host      string
+	time      time.Time
+	elapsed   float64
+	status    map[string]int64
+	prev      map[string]int64
+	stale     bool
+	deviating map[string]bool
+	// Per second rates of the counters, set instead of prev on the sum of
+	// several servers
+	rates map[string]float64
+}
+
+// Get a counter from the recorded status values. The delta is scaled to one
+// interval from the time actually elapsed, which is longer after a missed poll.
+func (s *sample) getCounter(v string) int64 {
+	delta := float64(s.status[v] - s.prev[v])
+	if s.rates != nil {
+		delta = s.rates[v] * float64(*interval)
+	} else if s.elapsed > 0 && *interval > 0 {
+		delta = delta * float64(*interval) / s.elapsed
+	}
 	if *average == true && *interval > 1 {
-		return (status[s] - prevStatus[s]) / *interval
+		return int64(math.Round(delta / float64(*interval)))
 	} else {
-		return status[s] - prevStatus[s]
+		return int64(math.Round(delta))
+	}
+}
+
+func (s *sample) getStatic(v string) int64 {
+	return s.status[v]
+}
+
+func (s *sample) value(c column) int64 {
+	v := s.getStatic(c.variable)
+	if c.counter {
+		v = s.getCounter(c.variable)
+	}
+	if c.divisor > 1 {
+		v /= c.divisor
 	}
+	return v
 }
 
-func getStatic(s string) int64 {
-	return status[s]
+func (s *sample) perSecond(v string) float64 {
+	if s.rates != nil {
+		return s.rates[v]
+	}
+	if s.elapsed <= 0 {
+		return 0
+	}
+	return float64(s.status[v]-s.prev[v]) / s.elapsed
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// batchThread is the JSON lines representation of a processlist entry.
+type batchThread struct {
+	Timestamp string  `json:"timestamp"`
+	Id        uint64  `json:"id"`
+	User      string  `json:"user"`
+	Host      string  `json:"host"`
+	Db        *string `json:"db"`
+	Command   string  `json:"command"`
+	Time      float64 `json:"time"`
+	State     string  `json:"state"`
+	Info      *string `json:"info"`
+}
+
+// Prints processlist snapshots to stdout without a terminal, every interval
+// until the number of iterations is reached (0 runs until interrupted). An
+// interruption returns normally so that the caller can print its reports.
+func runBatch(format string, iterations int) {
+	if format != "text" && format != "json" {
+		log.Fatalf("ERROR: Unknown output format %s, must be text or json", format)
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	enc := json.NewEncoder(os.Stdout)
+	for n := 0; iterations == 0 || n < iterations; n++ {
+		if n > 0 && src.Live() {
+			select {
+			case <-time.After(time.Duration(*interval) * time.Second):
+			case <-signals:
+				return
+			}
+		} else {
+			select {
+			case <-signals:
+				return
+			default:
+			}
+		}
+		err := src.Refresh()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			log.Fatalln("ERROR: Could not read snapshot", err)
+		}
+		plist := src.GetProcesslist()
+		pl.update(plist)
+		sampleRefresh(plist, src.Now())
+		ts := src.Now().Format(time.RFC3339)
+		if format == "json" {
+			for _, p := range pl.rows {
+				if err = enc.Encode(newBatchThread(ts, p)); err != nil {
+					log.Fatalln("ERROR: Could not write output", err)
+				}
+			}
+			continue
+		}
+		fmt.Printf("### %s  %d threads\n", ts, len(pl.rows))
+		fmt.Printf("%8s %-16s %-24s %-16s %-12s %10s %-24s %s\n", "Id", "User", "Host", "Database", "Command", "Time", "State", "Query")
+		for _, p := range pl.rows {
+			database := "NULL"
+			if p.Database.Valid {
+				database = p.Database.String
+			}
+			line := fmt.Sprintf("%8d %-16s %-24s %-16s %-12s %10.1f %-24s %s", p.Id, p.User, p.Host, database, p.Command, p.Time/1000, p.State, strings.Join(strings.Fields(p.Info.String), " "))
+			fmt.Println(strings.TrimRight(line, " "))
+		}
+		fmt.Println()
+	}
+}
+
+func newBatchThread(ts string, p dbhelper.Processlist) batchThread {
+	t := batchThread{Timestamp: ts, Id: p.Id, User: p.User, Host: p.Host, Command: p.Command, Time: p.Time / 1000, State: p.State}
+	if p.Database.Valid {
+		t.Db = &p.Database.String
+	}
+	if p.Info.Valid {
+		t.Info = &p.Info.String
+	}
+	return t
+}
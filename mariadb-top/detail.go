@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// detailView shows the full query text of one thread and optionally its plan.
+type detailView struct {
+	thread      dbhelper.Processlist
+	gone        bool
+	explainCols []string
+	explainRows [][]string
+	explainErr  error
+	explained   bool
+}
+
+var detail *detailView
+
+func openDetail(explain bool) {
+	p, ok := pl.current()
+	if !ok {
+		return
+	}
+	detail = &detailView{thread: p}
+	if explain {
+		detail.explain()
+	}
+}
+
+// Refreshes the thread from the last processlist, the pane keeps showing the
+// last known state once the thread has ended.
+func (d *detailView) update(plist []dbhelper.Processlist) {
+	for _, p := range plist {
+		if p.Id == d.thread.Id {
+			d.thread = p
+			d.gone = false
+			return
+		}
+	}
+	d.gone = true
+}
+
+// Asks the server for the plan of the running statement. SHOW EXPLAIN FOR is
+// tried first as it shows the actual plan on MariaDB, then the statement text
+// is explained in the thread's default database.
+func (d *detailView) explain() {
+	d.explained = true
+	d.explainCols, d.explainRows, d.explainErr = nil, nil, nil
+	if db == nil {
+		d.explainErr = fmt.Errorf("EXPLAIN is not available when replaying a snapshot")
+		return
+	}
+	cols, rows, err := dbhelper.ShowExplain(db, d.thread.Id)
+	if err != nil {
+		if !d.thread.Info.Valid || d.thread.Info.String == "" {
+			d.explainErr = err
+			return
+		}
+		cols, rows, err = dbhelper.Explain(db, d.thread.Database.String, d.thread.Info.String)
+	}
+	d.explainCols, d.explainRows, d.explainErr = cols, rows, err
+}
+
+func (d *detailView) key(ev termbox.Event) {
+	switch {
+	case ev.Key == termbox.KeyEsc, ev.Key == termbox.KeyEnter:
+		detail = nil
+	case ev.Ch == 'e':
+		d.explain()
+	}
+}
+
+func (d *detailView) draw(y int) {
+	w, h := termbox.Size()
+	p := d.thread
+	title := fmt.Sprintf("Thread %d  %s@%s  db: %s  %s %.1fs  %s", p.Id, p.User, p.Host, p.Database.String, p.Command, p.Time/1000, p.State)
+	if d.gone {
+		title += "  (ended)"
+	}
+	print_tb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, title)
+	y += 2
+	query := p.Info.String
+	if query == "" {
+		query = "(no statement running)"
+	}
+	for _, line := range wrap(query, w) {
+		if y >= h-1 {
+			return
+		}
+		print_tb(0, y, termbox.ColorGreen, termbox.ColorBlack, line)
+		y++
+	}
+	if !d.explained {
+		return
+	}
+	y++
+	if d.explainErr != nil {
+		print_tb(0, y, termbox.ColorRed, termbox.ColorBlack, "EXPLAIN failed: "+d.explainErr.Error())
+		return
+	}
+	for _, line := range formatTable(d.explainCols, d.explainRows) {
+		if y >= h-1 {
+			return
+		}
+		print_tb(0, y, termbox.ColorWhite, termbox.ColorBlack, line)
+		y++
+	}
+}
+
+// Splits text into lines of at most width characters, keeping line breaks.
+func wrap(text string, width int) []string {
+	if width < 1 {
+		width = 80
+	}
+	var lines []string
+	for _, l := range strings.Split(strings.Replace(text, "\t", "    ", -1), "\n") {
+		r := []rune(strings.TrimRight(l, "\r "))
+		for len(r) > width {
+			lines = append(lines, string(r[:width]))
+			r = r[width:]
+		}
+		lines = append(lines, string(r))
+	}
+	return lines
+}
+
+// Formats a result set as a table with one column width per field.
+func formatTable(cols []string, rows [][]string) []string {
+	widths := make([]int, len(cols))
+	for i, c := range cols {
+		widths[i] = utf8.RuneCountInString(c)
+	}
+	for _, r := range rows {
+		for i, v := range r {
+			if n := utf8.RuneCountInString(v); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	format := func(r []string) string {
+		f := make([]string, len(r))
+		for i, v := range r {
+			f[i] = fmt.Sprintf("%-*s", widths[i], v)
+		}
+		return strings.TrimRight(strings.Join(f, " | "), " ")
+	}
+	lines := []string{format(cols)}
+	sep := make([]string, len(cols))
+	for i := range cols {
+		sep[i] = strings.Repeat("-", widths[i])
+	}
+	lines = append(lines, strings.Join(sep, "-+-"))
+	for _, r := range rows {
+		lines = append(lines, format(r))
+	}
+	return lines
+}
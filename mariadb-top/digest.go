@@ -0,0 +1,233 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+	"github.com/tanji/mariadb-tools/digest"
+)
+
+// digestClass aggregates the processlist samples of one query fingerprint.
+type digestClass struct {
+	checksum    string
+	fingerprint string
+	example     string
+	database    string
+	samples     int
+	peak        int
+	time        time.Duration
+}
+
+// digestStats estimates where the server spends its time: every sample of a
+// running statement accounts for the time elapsed since the previous sample.
+type digestStats struct {
+	mu      sync.Mutex
+	classes map[string]*digestClass
+	samples int
+	active  int
+	elapsed time.Duration
+	self    []uint64
+	last    time.Time
+}
+
+var digests = digestStats{classes: make(map[string]*digestClass)}
+var digestView = tableView{}
+
+func (d *digestStats) add(plist []dbhelper.Processlist, weight time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.samples++
+	d.elapsed += weight
+	count := make(map[string]int)
+	for _, p := range plist {
+		if d.isSelf(p.Id) || (p.Command != "Query" && p.Command != "Execute") || !p.Info.Valid || p.Info.String == "" {
+			continue
+		}
+		f := digest.Fingerprint(p.Info.String)
+		c, ok := d.classes[f]
+		if !ok {
+			c = &digestClass{checksum: digest.Checksum(f), fingerprint: f, example: p.Info.String, database: p.Database.String}
+			d.classes[f] = c
+		}
+		c.samples++
+		c.time += weight
+		count[f]++
+		if count[f] > c.peak {
+			c.peak = count[f]
+		}
+		d.active++
+	}
+}
+
+// Returns the classes ranked by number of samples.
+func (d *digestStats) ranking() []digestClass {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	r := make([]digestClass, 0, len(d.classes))
+	for _, c := range d.classes {
+		r = append(r, *c)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].samples == r[j].samples {
+			return r[i].checksum < r[j].checksum
+		}
+		return r[i].samples > r[j].samples
+	})
+	return r
+}
+
+// Returns the number of samples, the running statements seen and the time
+// they cover, consistent with each other while the sampler goes on.
+func (d *digestStats) totals() (int, int, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.samples, d.active, d.elapsed
+}
+
+// Returns the ids of our own connections, including the ones the sampler
+// reopened.
+func (d *digestStats) own() []uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]uint64(nil), d.self...)
+}
+
+func (d *digestStats) isOwn(id uint64) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.isSelf(id)
+}
+
+// Same as isOwn, for callers already holding the lock.
+func (d *digestStats) isSelf(id uint64) bool {
+	for _, s := range d.self {
+		if s == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Remembers our own connections so that the processlist queries of the tool
+// are not sampled.
+func digestSelf(conns ...*sqlx.DB) {
+	for _, c := range conns {
+		var id uint64
+		if err := c.Get(&id, "SELECT CONNECTION_ID()"); err != nil {
+			log.Println("WARN : Could not get connection id", err)
+			continue
+		}
+		digests.self = append(digests.self, id)
+	}
+}
+
+// Samples the processlist every d until the program exits. The sampler has
+// its own connection so that it does not wait behind the display queries. A
+// failed query only loses its sample, the server may be restarting.
+func sampleDigests(conn *sqlx.DB, d time.Duration) {
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	failed := false
+	for range ticker.C {
+		plist, err := dbhelper.GetProcesslistErr(conn)
+		if err != nil {
+			failed = true
+			continue
+		}
+		if failed {
+			// The connection was probably reopened with a new id
+			var id uint64
+			if conn.Get(&id, "SELECT CONNECTION_ID()") == nil {
+				digests.mu.Lock()
+				digests.self = append(digests.self, id)
+				digests.mu.Unlock()
+			}
+			failed = false
+		}
+		digests.add(plist, d)
+	}
+}
+
+// Without the sampler the digest is built from the processlist of each
+// refresh, which is coarser but costs no extra query. Replayed snapshots are
+// always aggregated this way.
+func sampleRefresh(plist []dbhelper.Processlist, now time.Time) {
+	if *digestMode && db != nil && *sampleInterval > 0 {
+		return
+	}
+	weight := refreshEvery
+	if !digests.last.IsZero() {
+		weight = now.Sub(digests.last)
+	}
+	digests.last = now
+	digests.add(plist, weight)
+}
+
+func refreshDigest() {
+	ranking := digests.ranking()
+	samples, active, elapsed := digests.totals()
+	digestView.title = fmt.Sprintf("Query digest: %d samples over %s, %d running statements seen", samples, elapsed.Round(time.Second), active)
+	cols := []string{"Rank", "Id", "Share", "Est. time", "Samples", "Peak", "Db", "Fingerprint"}
+	var rows [][]string
+	for i, c := range ranking {
+		rows = append(rows, []string{
+			strconv.Itoa(i + 1),
+			c.checksum,
+			share(c.samples, active),
+			c.time.Round(time.Millisecond).String(),
+			strconv.Itoa(c.samples),
+			strconv.Itoa(c.peak),
+			c.database,
+			c.fingerprint,
+		})
+	}
+	digestView.set(cols, rows, nil)
+}
+
+func share(n int, total int) string {
+	if total == 0 {
+		return "0.0%"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(n)/float64(total))
+}
+
+// Prints the digest report at exit, the sampler may still be running.
+func digestReport(w io.Writer, top int) {
+	if !*digestMode {
+		return
+	}
+	digests.report(w, top)
+}
+
+// Prints the ranking with an example of each statement.
+func (d *digestStats) report(w io.Writer, top int) {
+	ranking := d.ranking()
+	samples, active, elapsed := d.totals()
+	fmt.Fprintf(w, "### Query digest: %d processlist samples over %s\n", samples, elapsed.Round(time.Second))
+	if len(ranking) == 0 {
+		fmt.Fprintln(w, "No running statement was sampled")
+		return
+	}
+	fmt.Fprintf(w, "%4s %-16s %7s %12s %8s %5s  %s\n", "Rank", "Id", "Share", "Est. time", "Samples", "Peak", "Fingerprint")
+	for i, c := range ranking {
+		if top > 0 && i >= top {
+			break
+		}
+		fmt.Fprintf(w, "%4d %-16s %7s %12s %8d %5d  %s\n", i+1, c.checksum, share(c.samples, active), c.time.Round(time.Millisecond), c.samples, c.peak, c.fingerprint)
+	}
+	fmt.Fprintln(w)
+	for i, c := range ranking {
+		if top > 0 && i >= top {
+			break
+		}
+		fmt.Fprintf(w, "# %d. %s db=%s\n%s\n\n", i+1, c.checksum, c.database, strings.TrimSpace(c.example))
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func running(id uint64, query string) dbhelper.Processlist {
+	return dbhelper.Processlist{Id: id, Command: "Query", Database: sql.NullString{String: "shop", Valid: true}, Info: sql.NullString{String: query, Valid: query != ""}}
+}
+
+func TestDigestAdd(t *testing.T) {
+	d := digestStats{classes: make(map[string]*digestClass), self: []uint64{1}}
+	d.add([]dbhelper.Processlist{
+		running(1, "SELECT * FROM information_schema.processlist"),
+		running(2, "SELECT * FROM t WHERE id = 1"),
+		running(3, "SELECT * FROM t WHERE id = 2"),
+		running(4, ""),
+		{Id: 5, Command: "Sleep", Info: sql.NullString{String: "SELECT 1", Valid: true}},
+	}, 100*time.Millisecond)
+	d.add([]dbhelper.Processlist{running(2, "UPDATE t SET a = 1")}, 100*time.Millisecond)
+
+	samples, active, elapsed := d.totals()
+	if samples != 2 || active != 3 || elapsed != 200*time.Millisecond {
+		t.Errorf("totals = %d, %d, %s, want 2, 3, 200ms", samples, active, elapsed)
+	}
+	r := d.ranking()
+	if len(r) != 2 {
+		t.Fatalf("%d classes, want 2", len(r))
+	}
+	tests := []struct {
+		name      string
+		got, want interface{}
+	}{
+		{"fingerprint", r[0].fingerprint, "select * from t where id = ?"},
+		{"samples", r[0].samples, 2},
+		{"peak", r[0].peak, 2},
+		{"time", r[0].time, 200 * time.Millisecond},
+		{"database", r[0].database, "shop"},
+		{"second", r[1].fingerprint, "update t set a = ?"},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s = %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+// Run with -race: the report is printed while the sampler goes on.
+func TestDigestReportWhileSampling(t *testing.T) {
+	d := digestStats{classes: make(map[string]*digestClass)}
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := uint64(0); ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			d.add([]dbhelper.Processlist{running(i, "SELECT * FROM t WHERE id = 1")}, time.Millisecond)
+		}
+	}()
+	var buf bytes.Buffer
+	for i := 0; i < 20; i++ {
+		buf.Reset()
+		d.report(&buf, 10)
+	}
+	close(stop)
+	wg.Wait()
+	if !strings.Contains(buf.String(), "### Query digest:") {
+		t.Errorf("report = %q", buf.String())
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+var userView = tableView{title: "Connections by user (most connections first)"}
+var hostView = tableView{title: "Connections by client host (most connections first)"}
+var dbView = tableView{title: "Connections by database (most connections first)"}
+
+// threadGroup counts the threads sharing a user, host or database, and keeps
+// the longest running statement among them.
+type threadGroup struct {
+	name     string
+	total    int
+	active   int
+	sleeping int
+	longest  dbhelper.Processlist
+}
+
+// Strips the client port from a processlist host, which also works for IPv6
+// addresses as the port is always the last field.
+func clientHost(h string) string {
+	i := strings.LastIndexByte(h, ':')
+	if i <= 0 || i == len(h)-1 || h[i-1] == ':' {
+		return h
+	}
+	if _, err := strconv.Atoi(h[i+1:]); err != nil {
+		return h
+	}
+	return h[:i]
+}
+
+func groupThreads(key func(p dbhelper.Processlist) string) []*threadGroup {
+	groups := make(map[string]*threadGroup)
+	for _, p := range pl.all {
+		k := key(p)
+		g, ok := groups[k]
+		if !ok {
+			g = &threadGroup{name: k}
+			groups[k] = g
+		}
+		g.total++
+		if p.Command == "Sleep" {
+			g.sleeping++
+			continue
+		}
+		g.active++
+		if p.Info.String != "" && (g.longest.Info.String == "" || p.Time > g.longest.Time) {
+			g.longest = p
+		}
+	}
+	r := make([]*threadGroup, 0, len(groups))
+	for _, g := range groups {
+		r = append(r, g)
+	}
+	sort.Slice(r, func(i, j int) bool {
+		if r[i].total == r[j].total {
+			return r[i].name < r[j].name
+		}
+		return r[i].total > r[j].total
+	})
+	return r
+}
+
+func setGroupView(t *tableView, label string, key func(p dbhelper.Processlist) string) {
+	cols := []string{label, "Connections", "Active", "Sleeping", "Longest", "Thread", "Query"}
+	var rows [][]string
+	for _, g := range groupThreads(key) {
+		longest, thread := "", ""
+		if g.longest.Info.String != "" {
+			longest = fmt.Sprintf("%.1fs", g.longest.Time/1000)
+			thread = strconv.FormatUint(g.longest.Id, 10)
+		}
+		rows = append(rows, []string{
+			g.name,
+			strconv.Itoa(g.total),
+			strconv.Itoa(g.active),
+			strconv.Itoa(g.sleeping),
+			longest,
+			thread,
+			oneLine(g.longest.Info.String),
+		})
+	}
+	t.set(cols, rows, nil)
+}
+
+func refreshUsers() {
+	setGroupView(&userView, "User", func(p dbhelper.Processlist) string {
+		return p.User
+	})
+}
+
+func refreshHosts() {
+	setGroupView(&hostView, "Host", func(p dbhelper.Processlist) string {
+		return clientHost(p.Host)
+	})
+}
+
+func refreshDatabases() {
+	setGroupView(&dbView, "Database", func(p dbhelper.Processlist) string {
+		if !p.Database.Valid {
+			return "NULL"
+		}
+		return p.Database.String
+	})
+}
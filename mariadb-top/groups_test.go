@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func TestClientHost(t *testing.T) {
+	tests := map[string]string{
+		"10.0.0.1:4000":     "10.0.0.1",
+		"db1.example:51234": "db1.example",
+		"localhost":         "localhost",
+		"[::1]:3306":        "[::1]",
+		"fe80::1:33060":     "fe80::1",
+		"::1":               "::1",
+		"host:":             "host:",
+		"host:name":         "host:name",
+	}
+	for h, want := range tests {
+		if got := clientHost(h); got != want {
+			t.Errorf("clientHost(%q) = %q, want %q", h, got, want)
+		}
+	}
+}
+
+func TestGroupThreads(t *testing.T) {
+	pl.all = append(append([]dbhelper.Processlist(nil), testThreads...), thread(5, "report", "stats", "Query", 90, ""), thread(6, "report", "", "Query", 3, "SELECT 1"))
+	defer func() { pl = plView{} }()
+
+	groups := groupThreads(func(p dbhelper.Processlist) string { return p.User })
+	want := []threadGroup{
+		{name: "report", total: 3, active: 3, longest: dbhelper.Processlist{Id: 3}},
+		{name: "app", total: 2, active: 1, sleeping: 1, longest: dbhelper.Processlist{Id: 1}},
+		{name: "root", total: 1, active: 1, longest: dbhelper.Processlist{Id: 4}},
+	}
+	if len(groups) != len(want) {
+		t.Fatalf("%d groups, want %d", len(groups), len(want))
+	}
+	for i, w := range want {
+		g := groups[i]
+		if g.name != w.name || g.total != w.total || g.active != w.active || g.sleeping != w.sleeping || g.longest.Id != w.longest.Id {
+			t.Errorf("group %d: %s %d/%d/%d longest %d, want %s %d/%d/%d longest %d", i,
+				g.name, g.total, g.active, g.sleeping, g.longest.Id, w.name, w.total, w.active, w.sleeping, w.longest.Id)
+		}
+	}
+
+	refreshDatabases()
+	var names []string
+	for _, r := range dbView.rows {
+		names = append(names, r[0])
+	}
+	// Equal counts are ordered by name
+	if want := []string{"NULL", "shop", "stats"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("database groups %v, want %v", names, want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Number of lines used by the title and the dashboard above the screens.
+const headerLines = 4
+
+// dashboard computes the header counters from the status values of the last
+// two refreshes.
+type dashboard struct {
+	version  string
+	hostname string
+	status   map[string]int64
+	prev     map[string]int64
+	time     time.Time
+	prevTime time.Time
+	lag      string
+}
+
+var dash dashboard
+
+var galeraStates = map[int64]string{
+	1: "Joining",
+	2: "Donor/Desynced",
+	3: "Joined",
+	4: "Synced",
+}
+
+func (d *dashboard) update(r refreshData) {
+	if d.version == "" && r.vars != nil {
+		d.version = r.vars["VERSION"]
+		d.hostname = r.vars["HOSTNAME"]
+	}
+	d.prev, d.prevTime = d.status, d.time
+	d.status, d.time = r.status, r.time
+	d.lag = r.lag
+}
+
+// How often a server without replication is checked again for a new channel.
+const replicaProbe = time.Minute
+
+// Time of the last check that found no replication channel. Only the refresh
+// goroutine uses it, one refresh runs at a time.
+var notReplicaSince time.Time
+
+// Returns the highest lag of all replication channels.
+func replicationLag() string {
+	if db == nil {
+		return "n/a"
+	}
+	if !notReplicaSince.IsZero() && time.Since(notReplicaSince) < replicaProbe {
+		return "not configured"
+	}
+	ss, err := dbhelper.GetAllSlavesStatus(db)
+	if err != nil {
+		return "n/a"
+	}
+	if len(ss) == 0 {
+		notReplicaSince = time.Now()
+		return "not configured"
+	}
+	notReplicaSince = time.Time{}
+	var lag int64
+	for _, s := range ss {
+		if !s.Seconds_Behind_Master.Valid {
+			return "stopped (" + s.Connection_name + ")"
+		}
+		if s.Seconds_Behind_Master.Int64 > lag {
+			lag = s.Seconds_Behind_Master.Int64
+		}
+	}
+	return fmt.Sprintf("%ds lag", lag)
+}
+
+// Returns the per second rate of a counter between the last two refreshes.
+func (d *dashboard) rate(name string) float64 {
+	elapsed := d.time.Sub(d.prevTime).Seconds()
+	if d.prev == nil || elapsed <= 0 {
+		return 0
+	}
+	return float64(d.status[name]-d.prev[name]) / elapsed
+}
+
+// Buffer pool hit rate over the last interval, or since startup before the
+// second refresh.
+func (d *dashboard) hitRate() string {
+	reads := d.status["INNODB_BUFFER_POOL_READS"] - d.prev["INNODB_BUFFER_POOL_READS"]
+	requests := d.status["INNODB_BUFFER_POOL_READ_REQUESTS"] - d.prev["INNODB_BUFFER_POOL_READ_REQUESTS"]
+	if requests <= 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("%.2f%%", 100*(1-float64(reads)/float64(requests)))
+}
+
+func (d *dashboard) galera() string {
+	size, ok := d.status["WSREP_CLUSTER_SIZE"]
+	if !ok || size == 0 {
+		return "off"
+	}
+	state, ok := galeraStates[d.status["WSREP_LOCAL_STATE"]]
+	if !ok {
+		state = "Initialized"
+	}
+	return fmt.Sprintf("%s, %d nodes", state, size)
+}
+
+func (d *dashboard) draw() {
+	title := "MariaDB Processlist Monitor"
+	if d.hostname != "" {
+		title += "  " + d.hostname
+	}
+	if d.version != "" {
+		title += "  " + d.version
+	}
+	title += "  up " + formatUptime(d.status["UPTIME"])
+	print_tb(0, 0, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, title)
+	slow := d.status["SLOW_QUERIES"] - d.prev["SLOW_QUERIES"]
+	if d.prev == nil {
+		slow = 0
+	}
+	printf_tb(0, 1, termbox.ColorWhite, termbox.ColorBlack, "QPS: %-10.1f Slow: %d (+%d)  Threads: %d connected, %d running  Buffer pool hit: %s",
+		d.rate("QUESTIONS"), d.status["SLOW_QUERIES"], slow, d.status["THREADS_CONNECTED"], d.status["THREADS_RUNNING"], d.hitRate())
+	printf_tb(0, 2, termbox.ColorWhite, termbox.ColorBlack, "Replication: %-24s Galera: %s", d.lag, d.galera())
+}
+
+func formatUptime(s int64) string {
+	days := s / 86400
+	s %= 86400
+	return fmt.Sprintf("%dd %02d:%02d:%02d", days, s/3600, (s%3600)/60, s%60)
+}
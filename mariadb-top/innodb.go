@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+var trxView = tableView{title: "InnoDB transactions (oldest first)"}
+var lockView = tableView{title: "InnoDB lock waits (blockers first, waiting threads below their blocker)"}
+var mdlView = tableView{title: "Metadata lock waits and the locks held on the same tables"}
+
+var errNotLive = fmt.Errorf("this screen needs a live server and is not available when replaying a snapshot")
+
+// Returns the processlist entries of the last refresh by thread id.
+func threadsById() map[uint64]dbhelper.Processlist {
+	m := make(map[uint64]dbhelper.Processlist, len(pl.all))
+	for _, p := range pl.all {
+		m[p.Id] = p
+	}
+	return m
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+func refreshTrx() {
+	if db == nil {
+		trxView.set(nil, nil, errNotLive)
+		return
+	}
+	trx, err := dbhelper.GetInnoDBTrx(db)
+	threads := threadsById()
+	cols := []string{"Thread", "User", "Trx id", "State", "Age", "Rows locked", "Rows modified", "Tables", "Isolation", "Operation", "Query"}
+	var rows [][]string
+	for _, t := range trx {
+		rows = append(rows, []string{
+			strconv.FormatUint(t.Trx_mysql_thread_id, 10),
+			threads[t.Trx_mysql_thread_id].User,
+			t.Trx_id,
+			t.Trx_state,
+			strconv.FormatInt(t.Trx_age, 10) + "s",
+			strconv.FormatUint(t.Trx_rows_locked, 10),
+			strconv.FormatUint(t.Trx_rows_modified, 10),
+			strconv.FormatUint(t.Trx_tables_locked, 10),
+			t.Trx_isolation_level,
+			t.Trx_operation_state.String,
+			oneLine(t.Trx_query.String),
+		})
+	}
+	trxView.set(cols, rows, err)
+}
+
+// lockNode is a row of the blocker tree. A thread waiting on several blockers
+// is listed below each of them, its own waiters only below the first one.
+type lockNode struct {
+	id    uint64
+	depth int
+	wait  *dbhelper.LockWait // nil for a root blocker
+	note  string
+}
+
+// Builds the blocker tree: threads holding locks without waiting themselves
+// are the roots, the ones blocking the most threads first, and each waiting
+// thread is listed below the thread blocking it. Blockers only reachable
+// through a cycle are a deadlock InnoDB has not resolved yet, they are listed
+// last.
+func lockTree(waits []dbhelper.LockWait) []lockNode {
+	blocked := make(map[uint64][]dbhelper.LockWait)
+	waiting := make(map[uint64]bool)
+	for _, w := range waits {
+		blocked[w.Blocking_thread] = append(blocked[w.Blocking_thread], w)
+		waiting[w.Waiting_thread] = true
+	}
+	var blockers, roots []uint64
+	for b, ws := range blocked {
+		blockers = append(blockers, b)
+		if !waiting[b] {
+			roots = append(roots, b)
+		}
+		// Longest waits first
+		sort.SliceStable(ws, func(i, j int) bool {
+			if ws[i].Wait_age.Int64 != ws[j].Wait_age.Int64 {
+				return ws[i].Wait_age.Int64 > ws[j].Wait_age.Int64
+			}
+			return ws[i].Waiting_thread < ws[j].Waiting_thread
+		})
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		if len(blocked[roots[i]]) != len(blocked[roots[j]]) {
+			return len(blocked[roots[i]]) > len(blocked[roots[j]])
+		}
+		return roots[i] < roots[j]
+	})
+	sort.Slice(blockers, func(i, j int) bool { return blockers[i] < blockers[j] })
+
+	var nodes []lockNode
+	path := make(map[uint64]bool)
+	visited := make(map[uint64]bool)
+	var walk func(id uint64, depth int, w *dbhelper.LockWait)
+	walk = func(id uint64, depth int, w *dbhelper.LockWait) {
+		n := lockNode{id: id, depth: depth, wait: w}
+		switch {
+		case path[id]:
+			n.note = "(cycle)"
+		case visited[id] && len(blocked[id]) > 0:
+			n.note = "(waiters above)"
+		}
+		nodes = append(nodes, n)
+		if n.note != "" || visited[id] {
+			return
+		}
+		visited[id] = true
+		path[id] = true
+		for i := range blocked[id] {
+			walk(blocked[id][i].Waiting_thread, depth+1, &blocked[id][i])
+		}
+		delete(path, id)
+	}
+	for _, r := range roots {
+		walk(r, 0, nil)
+	}
+	for _, b := range blockers {
+		if !visited[b] {
+			walk(b, 0, nil)
+		}
+	}
+	return nodes
+}
+
+func refreshLocks() {
+	if db == nil {
+		lockView.set(nil, nil, errNotLive)
+		return
+	}
+	waits, err := dbhelper.GetLockWaits(db)
+	if err != nil {
+		lockView.set(nil, nil, err)
+		return
+	}
+	threads := threadsById()
+	queries := make(map[uint64]string)
+	for _, w := range waits {
+		queries[w.Blocking_thread] = w.Blocking_query.String
+		queries[w.Waiting_thread] = w.Waiting_query.String
+	}
+	cols := []string{"Thread", "User", "Waiting", "Table", "Index", "Mode", "Query"}
+	var rows [][]string
+	for _, n := range lockTree(waits) {
+		label := strconv.FormatUint(n.id, 10)
+		if n.depth > 0 {
+			label = strings.Repeat("  ", n.depth-1) + "└─ " + label
+		}
+		if n.note != "" {
+			label += " " + n.note
+		}
+		query := queries[n.id]
+		if query == "" {
+			query = threads[n.id].Info.String
+		}
+		row := []string{label, threads[n.id].User, "blocker", "", "", "", oneLine(query)}
+		if w := n.wait; w != nil {
+			row[2] = "-"
+			if w.Wait_age.Valid {
+				row[2] = strconv.FormatInt(w.Wait_age.Int64, 10) + "s"
+			}
+			row[3], row[4], row[5] = w.Locked_table.String, w.Locked_index.String, w.Lock_mode.String
+		}
+		rows = append(rows, row)
+	}
+	lockView.set(cols, rows, nil)
+}
+
+// METADATA_LOCK_INFO only lists granted locks, so the holders shown are the
+// ones on tables named in the statement of a waiting thread.
+func refreshMDL() {
+	if db == nil {
+		mdlView.set(nil, nil, errNotLive)
+		return
+	}
+	locks, err := dbhelper.GetMetadataLocks(db)
+	if err != nil {
+		mdlView.set(nil, nil, fmt.Errorf("%s (load it with INSTALL SONAME 'metadata_lock_info')", err))
+		return
+	}
+	cols := []string{"Thread", "Role", "User", "Time", "Schema", "Table", "Mode", "Type", "Query"}
+	var rows [][]string
+	var waitQueries []string
+	for _, p := range pl.all {
+		if strings.Contains(p.State, "metadata lock") {
+			waitQueries = append(waitQueries, strings.ToLower(p.Info.String))
+			rows = append(rows, []string{strconv.FormatUint(p.Id, 10), "waiting", p.User, fmt.Sprintf("%.0fs", p.Time/1000), p.Database.String, "", "", p.State, oneLine(p.Info.String)})
+		}
+	}
+	threads := threadsById()
+	for _, l := range locks {
+		if !l.Table_name.Valid || l.Table_name.String == "" {
+			continue
+		}
+		relevant := len(waitQueries) == 0
+		for _, q := range waitQueries {
+			if strings.Contains(q, strings.ToLower(l.Table_name.String)) {
+				relevant = true
+				break
+			}
+		}
+		if !relevant {
+			continue
+		}
+		p := threads[l.Thread_id]
+		rows = append(rows, []string{strconv.FormatUint(l.Thread_id, 10), "holds", p.User, fmt.Sprintf("%.0fs", p.Time/1000), l.Table_schema.String, l.Table_name.String, l.Lock_mode, l.Lock_type, oneLine(p.Info.String)})
+	}
+	mdlView.set(cols, rows, nil)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func lockWait(blocking, waiting uint64, age int64) dbhelper.LockWait {
+	return dbhelper.LockWait{Blocking_thread: blocking, Waiting_thread: waiting, Wait_age: sql.NullInt64{Int64: age, Valid: true}}
+}
+
+// Renders the tree as one id per node, indented by depth.
+func treeString(nodes []lockNode) string {
+	var s []string
+	for _, n := range nodes {
+		line := strings.Repeat(" ", n.depth) + fmt.Sprint(n.id)
+		if n.note != "" {
+			line += " " + n.note
+		}
+		s = append(s, line)
+	}
+	return strings.Join(s, "|")
+}
+
+func TestLockTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		waits []dbhelper.LockWait
+		want  string
+	}{
+		{"none", nil, ""},
+		{"chain", []dbhelper.LockWait{lockWait(1, 2, 5), lockWait(2, 3, 1)}, "1| 2|  3"},
+		{"roots by waiters then id", []dbhelper.LockWait{lockWait(9, 4, 1), lockWait(7, 5, 1), lockWait(8, 6, 1), lockWait(8, 3, 1)},
+			"8| 3| 6|7| 5|9| 4"},
+		{"longest wait first", []dbhelper.LockWait{lockWait(1, 2, 1), lockWait(1, 3, 10)}, "1| 3| 2"},
+		{"two blockers are no cycle", []dbhelper.LockWait{lockWait(1, 3, 2), lockWait(2, 3, 1), lockWait(3, 4, 1)},
+			"1| 3|  4|2| 3 (waiters above)"},
+		{"deadlock", []dbhelper.LockWait{lockWait(1, 2, 1), lockWait(2, 1, 1)}, "1| 2|  1 (cycle)"},
+		{"deadlock next to a root", []dbhelper.LockWait{lockWait(5, 6, 1), lockWait(3, 2, 1), lockWait(2, 3, 1)},
+			"5| 6|2| 3|  2 (cycle)"},
+		{"cycle below a root", []dbhelper.LockWait{lockWait(1, 2, 1), lockWait(2, 3, 1), lockWait(3, 2, 1)},
+			"1| 2|  3|   2 (cycle)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := treeString(lockTree(tt.waits)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
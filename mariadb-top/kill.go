@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// Asks for confirmation, then kills the selected thread or its statement.
+func killSelected(query bool) {
+	p, ok := pl.current()
+	if !ok {
+		return
+	}
+	if !canKill() {
+		return
+	}
+	if dbhelper.IsSystemThread(p) {
+		message = fmt.Sprintf("Thread %d is a system thread and cannot be killed", p.Id)
+		return
+	}
+	if digests.isOwn(p.Id) {
+		message = fmt.Sprintf("Thread %d is a connection of mariadb-top", p.Id)
+		return
+	}
+	what := "thread"
+	if query {
+		what = "query of thread"
+	}
+	confirm(fmt.Sprintf("Kill %s %d (%s@%s, %.0fs)? [y/N] ", what, p.Id, p.User, p.Host, p.Time/1000), func() {
+		kill([]dbhelper.Processlist{p}, query)
+	})
+}
+
+// Asks for confirmation, then kills all the threads matching the filters.
+func killFiltered(query bool) {
+	if !canKill() {
+		return
+	}
+	if !pl.filtered() {
+		message = "Set a user, database, query or time filter before killing threads in bulk"
+		return
+	}
+	var threads []dbhelper.Processlist
+	for _, p := range pl.rows {
+		if !dbhelper.IsSystemThread(p) && !digests.isOwn(p.Id) {
+			threads = append(threads, p)
+		}
+	}
+	if len(threads) == 0 {
+		message = "No thread matches the filters"
+		return
+	}
+	what := "threads"
+	if query {
+		what = "queries of threads"
+	}
+	confirm(fmt.Sprintf("Kill %s for %d threads matching %s? [y/N] ", what, len(threads), pl.filters()), func() {
+		kill(threads, query)
+	})
+}
+
+func canKill() bool {
+	if db == nil {
+		message = "Threads cannot be killed when replaying a snapshot"
+		return false
+	}
+	return true
+}
+
+func kill(threads []dbhelper.Processlist, query bool) {
+	n, err := dbhelper.KillThreadList(db, threads, query, digests.own()...)
+	if err != nil {
+		message = fmt.Sprintf("Killed %d threads, then failed: %s", n, err)
+	} else {
+		message = fmt.Sprintf("Killed %d threads", n)
+	}
+	refresh()
+}
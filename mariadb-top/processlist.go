@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/nsf/termbox-go"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+var sortColumns = []string{"time", "id", "user", "host", "db", "command", "state"}
+
+// plView holds the sorting, filtering and scrolling state of the processlist.
+type plView struct {
+	all       []dbhelper.Processlist
+	rows      []dbhelper.Processlist
+	sortCol   int
+	ascending bool
+	hideSleep bool
+	user      string
+	database  string
+	query     *regexp.Regexp
+	minTime   float64
+	selected  int
+	selId     uint64
+	offset    int
+}
+
+var pl plView
+
+func (v *plView) update(plist []dbhelper.Processlist) {
+	v.all = plist
+	v.apply()
+}
+
+// Filters and sorts the last processlist, keeping the selection on the same
+// thread when it is still present.
+func (v *plView) apply() {
+	rows := make([]dbhelper.Processlist, 0, len(v.all))
+	for _, p := range v.all {
+		if v.match(p) {
+			rows = append(rows, p)
+		}
+	}
+	col := sortColumns[v.sortCol]
+	sort.SliceStable(rows, func(i, j int) bool {
+		less := lessThan(rows[i], rows[j], col)
+		if v.ascending {
+			return less
+		}
+		return lessThan(rows[j], rows[i], col)
+	})
+	v.rows = rows
+	for i, p := range rows {
+		if p.Id == v.selId {
+			v.selected = i
+			return
+		}
+	}
+	v.selectRow(v.selected)
+}
+
+func (v *plView) match(p dbhelper.Processlist) bool {
+	if v.hideSleep && p.Command == "Sleep" {
+		return false
+	}
+	if v.user != "" && !strings.Contains(p.User, v.user) {
+		return false
+	}
+	if v.database != "" && !strings.Contains(p.Database.String, v.database) {
+		return false
+	}
+	if v.query != nil && !v.query.MatchString(p.Info.String) {
+		return false
+	}
+	if v.minTime > 0 && p.Time/1000 < v.minTime {
+		return false
+	}
+	return true
+}
+
+// Returns true when a filter other than hiding idle threads is set.
+func (v *plView) filtered() bool {
+	return v.user != "" || v.database != "" || v.query != nil || v.minTime > 0
+}
+
+func lessThan(a, b dbhelper.Processlist, col string) bool {
+	switch col {
+	case "id":
+		return a.Id < b.Id
+	case "user":
+		return a.User < b.User
+	case "host":
+		return a.Host < b.Host
+	case "db":
+		return a.Database.String < b.Database.String
+	case "command":
+		return a.Command < b.Command
+	case "state":
+		return a.State < b.State
+	}
+	return a.Time < b.Time
+}
+
+func (v *plView) selectRow(i int) {
+	if i >= len(v.rows) {
+		i = len(v.rows) - 1
+	}
+	if i < 0 {
+		i = 0
+	}
+	v.selected = i
+	if i < len(v.rows) {
+		v.selId = v.rows[i].Id
+	}
+}
+
+// Returns the selected thread, or false when the list is empty.
+func (v *plView) current() (dbhelper.Processlist, bool) {
+	if v.selected < len(v.rows) {
+		return v.rows[v.selected], true
+	}
+	return dbhelper.Processlist{}, false
+}
+
+func (v *plView) filters() string {
+	var f []string
+	if v.hideSleep {
+		f = append(f, "no sleep")
+	}
+	if v.user != "" {
+		f = append(f, "user~"+v.user)
+	}
+	if v.database != "" {
+		f = append(f, "db~"+v.database)
+	}
+	if v.query != nil {
+		f = append(f, "query~/"+strings.TrimPrefix(v.query.String(), "(?i)")+"/")
+	}
+	if v.minTime > 0 {
+		f = append(f, fmt.Sprintf("time>=%gs", v.minTime))
+	}
+	if len(f) == 0 {
+		return "none"
+	}
+	return strings.Join(f, ", ")
+}
+
+// Handles the processlist keys, returns false if the key is not used here.
+func (v *plView) key(ev termbox.Event, page int) bool {
+	switch ev.Key {
+	case termbox.KeyArrowUp:
+		v.selectRow(v.selected - 1)
+	case termbox.KeyArrowDown:
+		v.selectRow(v.selected + 1)
+	case termbox.KeyPgup:
+		v.selectRow(v.selected - page)
+	case termbox.KeyPgdn:
+		v.selectRow(v.selected + page)
+	case termbox.KeyHome:
+		v.selectRow(0)
+	case termbox.KeyEnd:
+		v.selectRow(len(v.rows) - 1)
+	default:
+		switch ev.Ch {
+		case '>':
+			v.sortCol = (v.sortCol + 1) % len(sortColumns)
+		case '<':
+			v.sortCol = (v.sortCol + len(sortColumns) - 1) % len(sortColumns)
+		case 'r':
+			v.ascending = !v.ascending
+		case 'i':
+			v.hideSleep = !v.hideSleep
+		case 'u':
+			ask("Filter on user: ", func(s string) { v.user = s; v.apply() })
+		case 'd':
+			ask("Filter on database: ", func(s string) { v.database = s; v.apply() })
+		case '/':
+			ask("Filter on query regex: ", func(s string) {
+				if s == "" {
+					v.query = nil
+				} else if re, err := regexp.Compile("(?i)" + s); err != nil {
+					message = "Invalid regex: " + err.Error()
+				} else {
+					v.query = re
+				}
+				v.apply()
+			})
+		case 't':
+			ask("Filter on minimum time in seconds: ", func(s string) {
+				if s == "" {
+					v.minTime = 0
+				} else if t, err := strconv.ParseFloat(s, 64); err != nil || t < 0 {
+					message = "Invalid time: " + s
+				} else {
+					v.minTime = t
+				}
+				v.apply()
+			})
+		case 'c':
+			v.hideSleep = false
+			v.user = ""
+			v.database = ""
+			v.query = nil
+			v.minTime = 0
+		default:
+			return false
+		}
+		v.apply()
+	}
+	return true
+}
+
+// Draws the processlist from line y to the line above the footer.
+func (v *plView) draw(y int) {
+	w, h := termbox.Size()
+	order := "desc"
+	if v.ascending {
+		order = "asc"
+	}
+	printf_tb(0, y, termbox.ColorWhite, termbox.ColorBlack, "Threads: %d shown, %d total  Sort: %s %s  Filters: %s", len(v.rows), len(v.all), sortColumns[v.sortCol], order, v.filters())
+	y += 2
+	printf_tb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%8s %-12s %-20s %-12s %-10s %8s %-20s %s", "Id", "User", "Host", "Database", "Command", "Time", "State", "Query")
+	y++
+	page := h - y - 1
+	if page < 1 {
+		return
+	}
+	if v.selected < v.offset {
+		v.offset = v.selected
+	}
+	if v.selected >= v.offset+page {
+		v.offset = v.selected - page + 1
+	}
+	if v.offset > len(v.rows)-page {
+		v.offset = len(v.rows) - page
+	}
+	if v.offset < 0 {
+		v.offset = 0
+	}
+	for i := v.offset; i < len(v.rows) && i < v.offset+page; i++ {
+		p := v.rows[i]
+		database := "NULL"
+		if p.Database.Valid {
+			database = p.Database.String
+		}
+		fg, bg := termbox.ColorWhite, termbox.ColorBlack
+		if i == v.selected {
+			fg, bg = termbox.ColorBlack, termbox.ColorWhite
+		}
+		line := fmt.Sprintf("%8d %-12.12s %-20.20s %-12.12s %-10.10s %8.1f %-20.20s %s", p.Id, p.User, p.Host, database, p.Command, p.Time/1000, p.State, strings.Join(strings.Fields(p.Info.String), " "))
+		printf_tb(0, y, fg, bg, "%-*s", w, line)
+		y++
+	}
+}
+
+// Number of rows scrolled by page up and page down.
+func (v *plView) pageSize() int {
+	_, h := termbox.Size()
+	if h > headerLines+5 {
+		return h - headerLines - 4
+	}
+	return 1
+}
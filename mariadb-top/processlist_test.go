@@ -0,0 +1,123 @@
+package main
+
+import (
+	"database/sql"
+	"reflect"
+	"regexp"
+	"testing"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func thread(id uint64, user, database, command string, seconds float64, query string) dbhelper.Processlist {
+	return dbhelper.Processlist{
+		Id:       id,
+		User:     user,
+		Host:     "10.0.0.1:4000",
+		Database: sql.NullString{String: database, Valid: database != ""},
+		Command:  command,
+		Time:     seconds * 1000,
+		Info:     sql.NullString{String: query, Valid: query != ""},
+	}
+}
+
+var testThreads = []dbhelper.Processlist{
+	thread(1, "app", "shop", "Query", 12, "SELECT * FROM orders"),
+	thread(2, "app", "shop", "Sleep", 300, ""),
+	thread(3, "report", "stats", "Query", 45, "select count(*) from visits"),
+	thread(4, "root", "", "Query", 0.5, "SHOW PROCESSLIST"),
+}
+
+func ids(rows []dbhelper.Processlist) []uint64 {
+	var r []uint64
+	for _, p := range rows {
+		r = append(r, p.Id)
+	}
+	return r
+}
+
+func TestFilters(t *testing.T) {
+	tests := []struct {
+		name     string
+		view     plView
+		want     []uint64
+		filtered bool
+		filters  string
+	}{
+		{"none", plView{}, []uint64{2, 3, 1, 4}, false, "none"},
+		{"idle hidden", plView{hideSleep: true}, []uint64{3, 1, 4}, false, "no sleep"},
+		{"user", plView{user: "ap"}, []uint64{2, 1}, true, "user~ap"},
+		{"database", plView{database: "stat"}, []uint64{3}, true, "db~stat"},
+		{"query", plView{query: regexp.MustCompile("(?i)select")}, []uint64{3, 1}, true, "query~/select/"},
+		{"time", plView{minTime: 12}, []uint64{2, 3, 1}, true, "time>=12s"},
+		{"all", plView{hideSleep: true, user: "app", minTime: 1}, []uint64{1}, true, "no sleep, user~app, time>=1s"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := tt.view
+			v.update(testThreads)
+			if got := ids(v.rows); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows %v, want %v", got, tt.want)
+			}
+			if v.filtered() != tt.filtered {
+				t.Errorf("filtered() = %v, want %v", v.filtered(), tt.filtered)
+			}
+			if got := v.filters(); got != tt.filters {
+				t.Errorf("filters() = %q, want %q", got, tt.filters)
+			}
+		})
+	}
+}
+
+func TestSort(t *testing.T) {
+	tests := []struct {
+		col       string
+		ascending bool
+		want      []uint64
+	}{
+		{"time", false, []uint64{2, 3, 1, 4}},
+		{"time", true, []uint64{4, 1, 3, 2}},
+		{"id", true, []uint64{1, 2, 3, 4}},
+		{"user", true, []uint64{1, 2, 3, 4}},
+		{"db", false, []uint64{3, 1, 2, 4}},
+		{"command", true, []uint64{1, 3, 4, 2}},
+	}
+	for _, tt := range tests {
+		v := plView{ascending: tt.ascending}
+		for i, c := range sortColumns {
+			if c == tt.col {
+				v.sortCol = i
+			}
+		}
+		v.update(testThreads)
+		if got := ids(v.rows); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("sort on %s ascending %v: %v, want %v", tt.col, tt.ascending, got, tt.want)
+		}
+	}
+}
+
+func TestSelectionFollowsThread(t *testing.T) {
+	v := plView{}
+	v.update(testThreads)
+	v.selectRow(1)
+	if p, _ := v.current(); p.Id != 3 {
+		t.Fatalf("selected thread %d, want 3", p.Id)
+	}
+	// Thread 3 moves to the top once the idle thread is hidden
+	v.hideSleep = true
+	v.apply()
+	if p, _ := v.current(); p.Id != 3 || v.selected != 0 {
+		t.Errorf("selected thread %d at row %d, want 3 at row 0", p.Id, v.selected)
+	}
+	// When it is filtered out the selection stays on the same row
+	v.user = "app"
+	v.apply()
+	if p, _ := v.current(); p.Id != 1 {
+		t.Errorf("selected thread %d, want 1", p.Id)
+	}
+	v.user = "nobody"
+	v.apply()
+	if _, ok := v.current(); ok {
+		t.Error("a thread is selected in an empty list")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/nsf/termbox-go"
+)
+
+// tableView draws a scrollable result table, used by the screens other than
+// the processlist.
+type tableView struct {
+	title  string
+	cols   []string
+	rows   [][]string
+	err    error
+	offset int
+}
+
+func (t *tableView) set(cols []string, rows [][]string, err error) {
+	t.cols, t.rows, t.err = cols, rows, err
+}
+
+func (t *tableView) key(ev termbox.Event, page int) bool {
+	switch ev.Key {
+	case termbox.KeyArrowUp:
+		t.offset--
+	case termbox.KeyArrowDown:
+		t.offset++
+	case termbox.KeyPgup:
+		t.offset -= page
+	case termbox.KeyPgdn:
+		t.offset += page
+	case termbox.KeyHome:
+		t.offset = 0
+	case termbox.KeyEnd:
+		t.offset = len(t.rows)
+	default:
+		return false
+	}
+	return true
+}
+
+// Draws the title, the column header and as many rows as fit above the footer.
+func (t *tableView) draw(y int) {
+	_, h := termbox.Size()
+	print_tb(0, y, termbox.ColorWhite, termbox.ColorBlack, t.title)
+	y += 2
+	if t.err != nil {
+		print_tb(0, y, termbox.ColorRed, termbox.ColorBlack, t.err.Error())
+		return
+	}
+	if len(t.rows) == 0 {
+		print_tb(0, y, termbox.ColorWhite, termbox.ColorBlack, "Nothing to show")
+		return
+	}
+	lines := formatTable(t.cols, t.rows)
+	print_tb(0, y, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, lines[0])
+	y++
+	lines = lines[2:]
+	page := h - y - 1
+	if t.offset > len(lines)-page {
+		t.offset = len(lines) - page
+	}
+	if t.offset < 0 {
+		t.offset = 0
+	}
+	for i := t.offset; i < len(lines) && y < h-1; i++ {
+		print_tb(0, y, termbox.ColorWhite, termbox.ColorBlack, lines[i])
+		y++
+	}
+}
@@ -6,20 +6,35 @@ import (
 	"fmt"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/nsf/termbox-go"
 	"github.com/tanji/mariadb-tools/common"
 	"github.com/tanji/mariadb-tools/dbhelper"
-	"github.com/nsf/termbox-go"
+	"github.com/tanji/mariadb-tools/snapshot"
+	"io"
+	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 	"time"
 )
 
 var db *sqlx.DB
+var src snapshot.Source
 var version = flag.Bool("version", false, "Return version")
 var user = flag.String("user", "", "User for MariaDB login")
 var password = flag.String("password", "", "Password for MariaDB login")
 var host = flag.String("host", "", "MariaDB host IP address or FQDN")
 var socket = flag.String("socket", "/var/run/mysqld/mysqld.sock", "Path of MariaDB unix socket")
 var port = flag.String("port", "3306", "TCP Port of MariaDB server")
+var interval = flag.Int64("interval", 3, "Refresh interval in seconds")
+var batch = flag.Bool("batch", false, "Print processlist snapshots to stdout instead of running the interactive display")
+var iterations = flag.Int("iterations", 0, "Number of snapshots printed in batch mode (0 runs until interrupted)")
+var format = flag.String("format", "text", "Batch mode output format: text or json (JSON lines, one thread per line)")
+var digestMode = flag.Bool("digest", false, "Sample the processlist at -sample-interval into the query digest and print the digest report at exit")
+var sampleInterval = flag.Duration("sample-interval", 100*time.Millisecond, "Processlist sampling interval of the query digest")
+var digestTop = flag.Int("digest-top", 20, "Number of fingerprints printed in the digest report (0 prints all)")
+var replay = flag.String("replay", "", "Replay a snapshot file recorded with mariadb-status -record instead of connecting to a server")
 
 func print_tb(x, y int, fg, bg termbox.Attribute, msg string) {
 	for _, c := range msg {
@@ -40,50 +55,363 @@ func main() {
 		common.Version()
 	}
 
-	db = dbhelper.Connect(*user, *password, dbhelper.GetAddress(*host, *port, *socket))
+	if *replay != "" {
+		r, err := snapshot.OpenReplay(*replay)
+		if err != nil {
+			log.Fatalln("ERROR: Could not open snapshot file", err)
+		}
+		defer r.Close()
+		src = r
+	} else {
+		db = dbhelper.Connect(*user, *password, dbhelper.GetAddress(*host, *port, *socket))
+		defer db.Close()
+		// A single connection lets KillThreadList recognize and skip our own thread
+		db.SetMaxOpenConns(1)
+		src = snapshot.NewLiveSource(db)
+		if *digestMode && *sampleInterval > 0 {
+			sampler := dbhelper.Connect(*user, *password, dbhelper.GetAddress(*host, *port, *socket))
+			defer sampler.Close()
+			sampler.SetMaxOpenConns(1)
+			digestSelf(db, sampler)
+			go sampleDigests(sampler, *sampleInterval)
+		} else {
+			digestSelf(db)
+		}
+	}
+
+	refreshEvery = time.Duration(*interval) * time.Second
+	if refreshEvery < time.Second {
+		refreshEvery = time.Second
+	}
+
+	if *batch == true {
+		runBatch(*format, *iterations)
+		digestReport(os.Stdout, *digestTop)
+		return
+	}
 
-	defer db.Close()
 	err := termbox.Init()
 	if err != nil {
-		panic(err)
+		log.Fatalln("ERROR: Could not initialize terminal", err)
 	}
 	defer termbox.Close()
+	err = run()
+	// The report is printed once the terminal is restored
+	termbox.Close()
+	if err == io.EOF {
+		fmt.Println("End of snapshot file")
+		err = nil
+	}
+	digestReport(os.Stdout, *digestTop)
+	if err != nil {
+		log.Fatalln("ERROR:", err)
+	}
+}
+
+// Runs the event loop until the user quits, the program is interrupted or a
+// replayed snapshot file is over (io.EOF).
+func run() error {
+	events := make(chan termbox.Event)
+	go func() {
+		for {
+			events <- termbox.PollEvent()
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(refreshEvery)
+	defer ticker.Stop()
+	refresh()
+	draw()
 	for {
-		displayPl()
-		go ifKeyPressed()
+		select {
+		case ev := <-events:
+			switch ev.Type {
+			case termbox.EventKey:
+				prev := refreshEvery
+				if !handleKey(ev) {
+					return nil
+				}
+				if refreshEvery != prev {
+					ticker.Reset(refreshEvery)
+				}
+			case termbox.EventResize:
+				termbox.Clear(termbox.ColorWhite, termbox.ColorBlack)
+			case termbox.EventError:
+				return fmt.Errorf("terminal error: %w", ev.Err)
+			}
+		case r := <-refreshed:
+			if r.err == io.EOF {
+				return r.err
+			}
+			if r.err != nil {
+				return fmt.Errorf("could not read snapshot: %w", r.err)
+			}
+			apply(r)
+		case <-ticker.C:
+			// A refresh still running on a slow server is not queued again
+			if !paused && !refreshing {
+				refresh()
+			}
+		case <-signals:
+			return nil
+		}
+		draw()
 	}
 }
 
-func ifKeyPressed() {
-	switch ev := termbox.PollEvent(); ev.Type {
-	case termbox.EventKey:
-		if ev.Key == termbox.KeyCtrlS {
-			termbox.Sync()
+var refreshEvery time.Duration
+var paused bool
+
+// Changes the refresh interval by one second, within 1 to 60 seconds.
+func changeInterval(d time.Duration) {
+	refreshEvery += d
+	if refreshEvery < time.Second {
+		refreshEvery = time.Second
+	}
+	if refreshEvery > time.Minute {
+		refreshEvery = time.Minute
+	}
+}
+
+// prompt is a one line text input shown in the footer.
+type prompt struct {
+	label  string
+	text   []rune
+	single bool
+	done   func(string)
+}
+
+var input *prompt
+var message string
+
+func ask(label string, done func(string)) {
+	input = &prompt{label: label, done: done}
+}
+
+// confirm shows a yes/no question, done only runs when y is pressed.
+func confirm(label string, done func()) {
+	input = &prompt{label: label, single: true, done: func(s string) {
+		if s == "y" || s == "Y" {
+			done()
+		}
+	}}
+}
+
+// Handles a key press, returns false when the program must exit.
+func handleKey(ev termbox.Event) bool {
+	if ev.Key == termbox.KeyCtrlQ || ev.Key == termbox.KeyCtrlC {
+		return false
+	}
+	if input != nil && input.single {
+		p := input
+		input = nil
+		p.done(string(ev.Ch))
+		return true
+	}
+	if input != nil {
+		switch ev.Key {
+		case termbox.KeyEnter:
+			p := input
+			input = nil
+			p.done(string(p.text))
+		case termbox.KeyEsc:
+			input = nil
+		case termbox.KeyBackspace, termbox.KeyBackspace2:
+			if len(input.text) > 0 {
+				input.text = input.text[:len(input.text)-1]
+			}
+		case termbox.KeySpace:
+			input.text = append(input.text, ' ')
+		default:
+			if ev.Ch != 0 {
+				input.text = append(input.text, ev.Ch)
+			}
 		}
-		if ev.Key == termbox.KeyCtrlQ {
-			termbox.Close()
-			db.Close()
-			os.Exit(0)
+		return true
+	}
+	message = ""
+	if ev.Key == termbox.KeyCtrlS {
+		termbox.Sync()
+		return true
+	}
+	switch {
+	case ev.Ch == '+':
+		changeInterval(time.Second)
+		return true
+	case ev.Ch == '-':
+		changeInterval(-time.Second)
+		return true
+	case ev.Ch == 'p' || ev.Key == termbox.KeySpace:
+		paused = !paused
+		if !paused {
+			refresh()
 		}
+		return true
+	}
+	if detail != nil {
+		detail.key(ev)
+		return true
+	}
+	if ev.Ch == 'q' {
+		return false
+	}
+	if ev.Ch >= '1' && int(ev.Ch-'1') < len(screens) {
+		screen = int(ev.Ch - '1')
+		refreshScreen()
+		return true
+	}
+	if screen != screenProcesslist {
+		screens[screen].view.key(ev, pl.pageSize())
+		return true
+	}
+	switch {
+	case ev.Key == termbox.KeyEnter:
+		openDetail(false)
+	case ev.Ch == 'e':
+		openDetail(true)
+	case ev.Ch == 'k':
+		killSelected(false)
+	case ev.Ch == 'K':
+		killSelected(true)
+	case ev.Ch == 'x':
+		killFiltered(false)
+	case ev.Ch == 'X':
+		killFiltered(true)
+	default:
+		pl.key(ev, pl.pageSize())
+	}
+	return true
+}
+
+// refreshData is what a refresh reads from the server or the snapshot file.
+type refreshData struct {
+	err    error
+	time   time.Time
+	plist  []dbhelper.Processlist
+	status map[string]int64
+	vars   map[string]string
+	lag    string
+}
+
+var refreshed = make(chan refreshData, 1)
+var refreshing, refreshPending bool
+
+// Starts a refresh in the background, so that a slow server does not block
+// the keys. A refresh asked for while one is running follows it.
+func refresh() {
+	if refreshing {
+		refreshPending = true
+		return
+	}
+	refreshing = true
+	vars := dash.version == ""
+	go func() {
+		refreshed <- fetch(vars)
+	}()
+}
+
+// Reads the data of a refresh. It only uses the source and the server, the
+// screens are updated by apply in the event loop.
+func fetch(vars bool) refreshData {
+	var r refreshData
+	if r.err = src.Refresh(); r.err != nil {
+		return r
+	}
+	r.time = src.Now()
+	r.plist = src.GetProcesslist()
+	r.status = src.GetStatusAsInt()
+	if vars {
+		r.vars, _ = src.GetVariables()
+	}
+	r.lag = replicationLag()
+	return r
+}
+
+// Updates the screens with the data of a finished refresh.
+func apply(r refreshData) {
+	refreshing = false
+	dash.update(r)
+	pl.update(r.plist)
+	sampleRefresh(r.plist, r.time)
+	if detail != nil {
+		detail.update(r.plist)
+	}
+	refreshScreen()
+	if refreshPending {
+		refreshPending = false
+		refresh()
 	}
 }
 
-func displayPl() {
+const screenProcesslist = 0
+
+// The screens selected with the number keys. Screens other than the
+// processlist only query the server while they are displayed.
+var screens = []struct {
+	name    string
+	view    *tableView
+	refresh func()
+}{
+	{"processlist", nil, nil},
+	{"transactions", &trxView, refreshTrx},
+	{"lock waits", &lockView, refreshLocks},
+	{"metadata locks", &mdlView, refreshMDL},
+	{"query digest", &digestView, refreshDigest},
+	{"users", &userView, refreshUsers},
+	{"hosts", &hostView, refreshHosts},
+	{"databases", &dbView, refreshDatabases},
+}
+
+var screen = screenProcesslist
+
+func refreshScreen() {
+	if screens[screen].refresh != nil {
+		screens[screen].refresh()
+	}
+}
+
+func screenList() string {
+	var names []string
+	for i, s := range screens {
+		names = append(names, fmt.Sprintf("%d:%s", i+1, s.name))
+	}
+	return strings.Join(names, " ")
+}
+
+func draw() {
 	termbox.Clear(termbox.ColorWhite, termbox.ColorBlack)
-	print_tb(0, 0, termbox.ColorWhite, termbox.ColorBlack, "MariaDB Processlist Monitor")
-	plist := dbhelper.GetProcesslist(db)
-	printf_tb(0, 2, termbox.ColorWhite|termbox.AttrBold, termbox.ColorBlack, "%8s %8s %10s %10s %20s %8s %20s", "Id", "User", "Host", "Database", "Command", "Time", "State")
-	vy := 3
-	for _, v := range plist {
-		var database string
-		if v.Database.Valid {
-			database = v.Database.String
-		} else {
-			database = "NULL"
-		}
-		printf_tb(0, vy, termbox.ColorWhite, termbox.ColorBlack, "%8.8d %8.8s %10.10s %10.10v %20.20s %8.2f %20.20s", v.Id, v.User, v.Host, database, v.Command, v.Time*1000, v.State)
-		vy++
+	dash.draw()
+	w, _ := termbox.Size()
+	state := fmt.Sprintf("refresh %s", refreshEvery)
+	if paused {
+		state = "PAUSED"
+	}
+	print_tb(w-len(state), 0, termbox.ColorYellow, termbox.ColorBlack, state)
+	help := "q:quit +/-:interval p:pause " + screenList() + " </>:sort r:reverse i:idle u:user d:db /:query t:time c:clear enter:details e:explain k:kill K:kill query x/X:kill filtered"
+	switch {
+	case detail != nil:
+		detail.draw(headerLines)
+		help = "esc:back e:explain"
+	case screen != screenProcesslist:
+		screens[screen].view.draw(headerLines)
+		help = "q:quit +/-:interval p:pause " + screenList()
+	default:
+		pl.draw(headerLines)
+	}
+	_, h := termbox.Size()
+	switch {
+	case input != nil:
+		printf_tb(0, h-1, termbox.ColorYellow, termbox.ColorBlack, "%s%s", input.label, string(input.text))
+		termbox.SetCursor(len(input.label)+len(input.text), h-1)
+	case message != "":
+		print_tb(0, h-1, termbox.ColorRed, termbox.ColorBlack, message)
+		termbox.HideCursor()
+	default:
+		print_tb(0, h-1, termbox.ColorCyan, termbox.ColorBlack, help)
+		termbox.HideCursor()
 	}
 	termbox.Flush()
-	time.Sleep(time.Duration(3) * time.Second)
 }
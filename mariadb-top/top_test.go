@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"testing"
+	"time"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+// fakeSource replays a list of processlists, blocking each Refresh until
+// release is signaled when it is set.
+type fakeSource struct {
+	lists   [][]dbhelper.Processlist
+	n       int
+	release chan struct{}
+}
+
+func (f *fakeSource) Refresh() error {
+	if f.release != nil {
+		<-f.release
+	}
+	if f.n >= len(f.lists) {
+		return io.EOF
+	}
+	f.n++
+	return nil
+}
+func (f *fakeSource) Now() time.Time                   { return time.Unix(int64(f.n), 0) }
+func (f *fakeSource) GetStatus() map[string]string     { return nil }
+func (f *fakeSource) GetStatusAsInt() map[string]int64 { return map[string]int64{"UPTIME": int64(f.n)} }
+func (f *fakeSource) GetVariables() (map[string]string, error) {
+	return map[string]string{"VERSION": "10.6.12-MariaDB"}, nil
+}
+func (f *fakeSource) GetProcesslist() []dbhelper.Processlist { return f.lists[f.n-1] }
+func (f *fakeSource) GetEngineInnoDBStatus() (string, error) { return "", nil }
+func (f *fakeSource) Live() bool                             { return false }
+
+func TestRefresh(t *testing.T) {
+	fake := &fakeSource{
+		lists:   [][]dbhelper.Processlist{{{Id: 1}}, {{Id: 1}, {Id: 2}}, {{Id: 3}}},
+		release: make(chan struct{}),
+	}
+	src, db = fake, nil
+	defer func() { src, pl, dash = nil, plView{}, dashboard{} }()
+
+	refresh()
+	if !refreshing {
+		t.Fatal("no refresh running")
+	}
+	// Asked for while the source is slow: queued, not run concurrently
+	refresh()
+	if !refreshPending {
+		t.Fatal("second refresh not queued")
+	}
+	fake.release <- struct{}{}
+	apply(<-refreshed)
+	if len(pl.all) != 1 || dash.version != "10.6.12-MariaDB" || dash.status["UPTIME"] != 1 || dash.lag != "n/a" {
+		t.Errorf("after the first refresh: %d threads, version %q, status %v, lag %q", len(pl.all), dash.version, dash.status, dash.lag)
+	}
+	if !refreshing || refreshPending {
+		t.Fatal("the queued refresh did not start")
+	}
+	fake.release <- struct{}{}
+	apply(<-refreshed)
+	if len(pl.all) != 2 || dash.prev["UPTIME"] != 1 || dash.status["UPTIME"] != 2 {
+		t.Errorf("after the second refresh: %d threads, prev %v, status %v", len(pl.all), dash.prev, dash.status)
+	}
+	if refreshing {
+		t.Fatal("refresh still marked as running")
+	}
+	fake.n = len(fake.lists)
+	refresh()
+	fake.release <- struct{}{}
+	if r := <-refreshed; r.err != io.EOF {
+		t.Errorf("got %v at the end of the snapshot, want EOF", r.err)
+	}
+	refreshing = false
+}
@@ -0,0 +1,232 @@
+// Package snapshot records server status snapshots to a compressed file and
+// replays them as a data source for the monitoring commands.
+package snapshot
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+type Snapshot struct {
+	Time         time.Time
+	Status       map[string]int64
+	Variables    map[string]string
+	Processlist  []dbhelper.Processlist
+	InnodbStatus string
+	// Errors holds the error of each section that could not be read, by
+	// field name. The other sections are still recorded.
+	Errors map[string]string `json:",omitempty"`
+}
+
+func (s *Snapshot) fail(section string, err error) error {
+	if s.Errors == nil {
+		s.Errors = make(map[string]string)
+	}
+	s.Errors[section] = err.Error()
+	return fmt.Errorf("%s: %w", section, err)
+}
+
+// Returns the recorded error of a section, if any.
+func (s *Snapshot) sectionErr(section string) error {
+	if msg, ok := s.Errors[section]; ok {
+		return errors.New(msg)
+	}
+	return nil
+}
+
+// Source provides the server data displayed by mariadb-status and mariadb-top,
+// either from a live server or from a recorded snapshot file.
+// Refresh must be called before reading each new sample.
+type Source interface {
+	Refresh() error
+	Now() time.Time
+	GetStatus() map[string]string
+	GetStatusAsInt() map[string]int64
+	GetVariables() (map[string]string, error)
+	GetProcesslist() []dbhelper.Processlist
+	GetEngineInnoDBStatus() (string, error)
+	Live() bool
+}
+
+// Take reads a snapshot from a live server. A section that fails is recorded
+// in Errors and the snapshot holds the others, the returned error lists the
+// failed sections.
+func Take(db *sqlx.DB) (Snapshot, error) {
+	var err error
+	var errs []error
+	s := Snapshot{Time: time.Now()}
+	if s.Status, err = dbhelper.GetStatusAsIntErr(db); err != nil {
+		errs = append(errs, s.fail("Status", err))
+	}
+	if s.Variables, err = dbhelper.GetVariables(db); err != nil {
+		errs = append(errs, s.fail("Variables", err))
+	}
+	if s.Processlist, err = dbhelper.GetProcesslistErr(db); err != nil {
+		errs = append(errs, s.fail("Processlist", err))
+	}
+	if s.InnodbStatus, err = dbhelper.GetEngineInnoDBStatus(db); err != nil {
+		errs = append(errs, s.fail("InnodbStatus", err))
+	}
+	return s, errors.Join(errs...)
+}
+
+/* Live source */
+
+type LiveSource struct {
+	db *sqlx.DB
+}
+
+func NewLiveSource(db *sqlx.DB) *LiveSource {
+	return &LiveSource{db: db}
+}
+
+func (l *LiveSource) Refresh() error {
+	return nil
+}
+
+func (l *LiveSource) Now() time.Time {
+	return time.Now()
+}
+
+func (l *LiveSource) GetStatus() map[string]string {
+	return dbhelper.GetStatus(l.db)
+}
+
+func (l *LiveSource) GetStatusAsInt() map[string]int64 {
+	return dbhelper.GetStatusAsInt(l.db)
+}
+
+func (l *LiveSource) GetVariables() (map[string]string, error) {
+	return dbhelper.GetVariables(l.db)
+}
+
+func (l *LiveSource) GetProcesslist() []dbhelper.Processlist {
+	return dbhelper.GetProcesslist(l.db)
+}
+
+func (l *LiveSource) GetEngineInnoDBStatus() (string, error) {
+	return dbhelper.GetEngineInnoDBStatus(l.db)
+}
+
+func (l *LiveSource) Live() bool {
+	return true
+}
+
+/* Snapshot files are gzipped JSON lines, one snapshot per line. */
+
+type Writer struct {
+	f  *os.File
+	gz *gzip.Writer
+	js *json.Encoder
+}
+
+func Create(path string) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	gz := gzip.NewWriter(f)
+	return &Writer{f: f, gz: gz, js: json.NewEncoder(gz)}, nil
+}
+
+// Write appends a snapshot and flushes the compressor, so that a file cut
+// short by a crash can still be replayed up to the last complete snapshot.
+func (w *Writer) Write(s Snapshot) error {
+	if err := w.js.Encode(s); err != nil {
+		return err
+	}
+	return w.gz.Flush()
+}
+
+func (w *Writer) Close() error {
+	if err := w.gz.Close(); err != nil {
+		w.f.Close()
+		return err
+	}
+	return w.f.Close()
+}
+
+/* Replay source */
+
+type ReplaySource struct {
+	f   *os.File
+	gz  *gzip.Reader
+	js  *json.Decoder
+	cur Snapshot
+}
+
+func OpenReplay(path string) (*ReplaySource, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &ReplaySource{f: f, gz: gz, js: json.NewDecoder(gz)}, nil
+}
+
+// Refresh advances to the next recorded snapshot and returns io.EOF once the
+// file is exhausted. A truncated last snapshot is treated as the end of file.
+func (r *ReplaySource) Refresh() error {
+	var s Snapshot
+	err := r.js.Decode(&s)
+	if err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		return err
+	}
+	r.cur = s
+	return nil
+}
+
+func (r *ReplaySource) Now() time.Time {
+	return r.cur.Time
+}
+
+// Snapshots only record the numeric statuses.
+func (r *ReplaySource) GetStatus() map[string]string {
+	st := make(map[string]string, len(r.cur.Status))
+	for k, v := range r.cur.Status {
+		st[k] = strconv.FormatInt(v, 10)
+	}
+	return st
+}
+
+func (r *ReplaySource) GetStatusAsInt() map[string]int64 {
+	return r.cur.Status
+}
+
+func (r *ReplaySource) GetVariables() (map[string]string, error) {
+	return r.cur.Variables, r.cur.sectionErr("Variables")
+}
+
+func (r *ReplaySource) GetProcesslist() []dbhelper.Processlist {
+	return r.cur.Processlist
+}
+
+func (r *ReplaySource) GetEngineInnoDBStatus() (string, error) {
+	return r.cur.InnodbStatus, r.cur.sectionErr("InnodbStatus")
+}
+
+func (r *ReplaySource) Live() bool {
+	return false
+}
+
+func (r *ReplaySource) Close() error {
+	r.gz.Close()
+	return r.f.Close()
+}
@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/tanji/mariadb-tools/dbhelper"
+)
+
+func TestReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.gz")
+	w, err := Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full := Snapshot{
+		Time:         time.Unix(1700000000, 0),
+		Status:       map[string]int64{"QUESTIONS": 42},
+		Variables:    map[string]string{"VERSION": "10.6.12-MariaDB"},
+		Processlist:  []dbhelper.Processlist{{Id: 8, User: "app", Command: "Query"}},
+		InnodbStatus: "BUFFER POOL AND MEMORY",
+	}
+	partial := Snapshot{Time: time.Unix(1700000001, 0), Status: map[string]int64{"QUESTIONS": 43}}
+	partial.fail("Variables", errors.New("lost connection"))
+	partial.fail("InnodbStatus", errors.New("lost connection"))
+	for _, s := range []Snapshot{full, partial} {
+		if err = w.Write(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenReplay(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	tests := []struct {
+		name     string
+		time     time.Time
+		version  string
+		threads  int
+		innodb   string
+		failures bool
+	}{
+		{"full", full.Time, "10.6.12-MariaDB", 1, "BUFFER POOL AND MEMORY", false},
+		{"partial", partial.Time, "", 0, "", true},
+	}
+	for _, tt := range tests {
+		if err := r.Refresh(); err != nil {
+			t.Fatalf("%s: %s", tt.name, err)
+		}
+		vars, verr := r.GetVariables()
+		innodb, ierr := r.GetEngineInnoDBStatus()
+		if !r.Now().Equal(tt.time) || vars["VERSION"] != tt.version || len(r.GetProcesslist()) != tt.threads || innodb != tt.innodb {
+			t.Errorf("%s: replayed %+v", tt.name, r.cur)
+		}
+		if (verr != nil) != tt.failures || (ierr != nil) != tt.failures {
+			t.Errorf("%s: errors %v and %v", tt.name, verr, ierr)
+		}
+		if r.GetStatusAsInt() == nil {
+			t.Errorf("%s: status not replayed", tt.name)
+		}
+	}
+	if err := r.Refresh(); err != io.EOF {
+		t.Errorf("got %v after the last snapshot, want EOF", err)
+	}
+}